@@ -69,11 +69,16 @@ func Unmarshal(dataset []byte, secretKey []byte) ([]byte, error) {
 		return nil, err
 	}
 
+	if len(dataset) < aes.BlockSize {
+		return nil, fmt.Errorf("dataset is too short")
+	}
+
 	iv := dataset[:aes.BlockSize]
-	ciphertext := dataset[aes.BlockSize:]
+	plaintext := make([]byte, len(dataset)-aes.BlockSize)
 
+	// the plaintext is a new buffer, so the dataset of the caller is left intact
 	stream := cipher.NewCFBDecrypter(block, iv)
-	stream.XORKeyStream(ciphertext, ciphertext)
+	stream.XORKeyStream(plaintext, dataset[aes.BlockSize:])
 
-	return ciphertext, nil
+	return plaintext, nil
 }
@@ -51,3 +51,27 @@ func TestMarshalAndUnmarshalString(t *testing.T) {
 		t.Errorf("returned incorrect result")
 	}
 }
+
+func FuzzAESUnmarshal(f *testing.F) {
+	secretKey := uuid.NewV4().Bytes()
+	dataset, err := Marshal(uuid.NewV4().Bytes(), secretKey)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(dataset)
+	f.Add([]byte{})
+	f.Add(dataset[:3])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		original := bytes.Clone(data)
+		if _, err := Unmarshal(data, secretKey); err != nil && len(data) >= 16 {
+			t.Errorf("dataset of %d bytes was rejected; details: %s", len(data), err.Error())
+		}
+		if !bytes.Equal(data, original) {
+			t.Errorf("dataset was modified")
+		}
+		if _, err := Open(data, nil, secretKey); err == nil {
+			t.Errorf("random dataset was opened")
+		}
+	})
+}
@@ -0,0 +1,50 @@
+package aes
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+)
+
+// Seal encrypts and authenticates the dataset with AES-GCM.
+// The random nonce is prepended to the returned ciphertext, additionalData is authenticated but not encrypted.
+func Seal(dataset []byte, additionalData []byte, secretKey []byte) ([]byte, error) {
+	aead, err := newGCM(secretKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(dataset)+aead.Overhead())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, dataset, additionalData), nil
+}
+
+// Open decrypts the dataset produced by Seal and verifies its authenticity.
+func Open(dataset []byte, additionalData []byte, secretKey []byte) ([]byte, error) {
+	aead, err := newGCM(secretKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(dataset) < aead.NonceSize()+aead.Overhead() {
+		return nil, fmt.Errorf("dataset is too short")
+	}
+
+	nonce := dataset[:aead.NonceSize()]
+	ciphertext := dataset[aead.NonceSize():]
+
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newGCM(secretKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(secretKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,60 @@
+package aes
+
+import (
+	"bytes"
+	"github.com/twinj/uuid"
+	"testing"
+)
+
+func TestSealAndOpen(t *testing.T) {
+	secretKey := uuid.NewV4().Bytes()
+	additionalData := uuid.NewV4().Bytes()
+	dataset := uuid.NewV4().Bytes()
+
+	res, err := Seal(dataset, additionalData, secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res) == 0 || bytes.Contains(res, dataset) {
+		t.Errorf("returned incorrect result")
+	}
+
+	tmp, err := Open(res, additionalData, secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Compare(dataset, tmp) != 0 {
+		t.Errorf("returned incorrect result")
+	}
+}
+
+func TestOpen_Tampered(t *testing.T) {
+	secretKey := uuid.NewV4().Bytes()
+	additionalData := uuid.NewV4().Bytes()
+	dataset := uuid.NewV4().Bytes()
+
+	res, err := Seal(dataset, additionalData, secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range res {
+		tampered := bytes.Clone(res)
+		tampered[i] ^= 0x01
+		if _, err = Open(tampered, additionalData, secretKey); err == nil {
+			t.Fatalf("tampered byte %d was not detected", i)
+		}
+	}
+
+	if _, err = Open(res, uuid.NewV4().Bytes(), secretKey); err == nil {
+		t.Fatalf("tampered additional data was not detected")
+	}
+	if _, err = Open(res, additionalData, uuid.NewV4().Bytes()); err == nil {
+		t.Fatalf("incorrect secret key was not detected")
+	}
+	if _, err = Open(res[:8], additionalData, secretKey); err == nil {
+		t.Fatalf("truncated dataset was not detected")
+	}
+}
@@ -0,0 +1,71 @@
+package crypt
+
+import (
+	"crypto/md5"
+)
+
+// maxAPR1SaltLength is the maximum length of the salt of APR1, the longer salt is truncated.
+const maxAPR1SaltLength = 8
+
+// APR1 computes the Apache MD5-crypt hash of the password in the format "$apr1$salt$hash".
+func APR1(password []byte, salt []byte) string {
+	if len(salt) > maxAPR1SaltLength {
+		salt = salt[:maxAPR1SaltLength]
+	}
+
+	alt := md5.New()
+	alt.Write(password)
+	alt.Write(salt)
+	alt.Write(password)
+	altSum := alt.Sum(nil)
+
+	h := md5.New()
+	h.Write(password)
+	h.Write([]byte(prefixAPR1))
+	h.Write(salt)
+	for i := len(password); i > 0; i -= md5.Size {
+		h.Write(altSum[:min(i, md5.Size)])
+	}
+	for i := len(password); i != 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(password[:1])
+		}
+	}
+	sum := h.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		h = md5.New()
+		if i&1 != 0 {
+			h.Write(password)
+		} else {
+			h.Write(sum)
+		}
+		if i%3 != 0 {
+			h.Write(salt)
+		}
+		if i%7 != 0 {
+			h.Write(password)
+		}
+		if i&1 != 0 {
+			h.Write(sum)
+		} else {
+			h.Write(password)
+		}
+		sum = h.Sum(nil)
+	}
+
+	b := make([]byte, 0, len(prefixAPR1)+len(salt)+1+22)
+	b = append(b, prefixAPR1...)
+	b = append(b, salt...)
+	b = append(b, '$')
+	b = appendBase64(b, sum[0], sum[6], sum[12], 4)
+	b = appendBase64(b, sum[1], sum[7], sum[13], 4)
+	b = appendBase64(b, sum[2], sum[8], sum[14], 4)
+	b = appendBase64(b, sum[3], sum[9], sum[15], 4)
+	b = appendBase64(b, sum[4], sum[10], sum[5], 4)
+	b = appendBase64(b, 0, 0, sum[11], 2)
+
+	return string(b)
+}
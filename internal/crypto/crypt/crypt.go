@@ -0,0 +1,92 @@
+package crypt
+
+import (
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnsupported is returned by Verify if the hash scheme is not supported (e.g. bcrypt).
+var ErrUnsupported = errors.New("unsupported hash scheme")
+
+// the prefixes of the supported hash schemes
+const (
+	prefixSHA1   = "{SHA}"
+	prefixAPR1   = "$apr1$"
+	prefixSHA256 = "$5$"
+	prefixSHA512 = "$6$"
+)
+
+// Verify reports whether the password matches the hash in one of the formats of the htpasswd file:
+// {SHA} (base64 of SHA-1), $apr1$ (Apache MD5-crypt), $5$ and $6$ (SHA-256 and SHA-512 crypt).
+func Verify(password []byte, hash string) (bool, error) {
+	var computed string
+	switch {
+	case strings.HasPrefix(hash, prefixSHA1):
+		sum := sha1.Sum(password)
+		computed = prefixSHA1 + base64.StdEncoding.EncodeToString(sum[:])
+	case strings.HasPrefix(hash, prefixAPR1):
+		salt, _, _ := strings.Cut(hash[len(prefixAPR1):], "$")
+		computed = APR1(password, []byte(salt))
+	case strings.HasPrefix(hash, prefixSHA256), strings.HasPrefix(hash, prefixSHA512):
+		h, err := shaCryptVerify(password, hash)
+		if err != nil {
+			return false, err
+		}
+		computed = h
+	default:
+		return false, ErrUnsupported
+	}
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1, nil
+}
+
+// Supported reports whether the scheme of the hash is supported by Verify.
+func Supported(hash string) bool {
+	for _, prefix := range []string{prefixSHA1, prefixAPR1, prefixSHA256, prefixSHA512} {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// shaCryptVerify computes the SHA-256 or SHA-512 crypt hash with the salt and rounds of the hash.
+func shaCryptVerify(password []byte, hash string) (string, error) {
+	prefix := hash[:3]
+	settings := strings.Split(hash[len(prefix):], "$")
+	if len(settings) < 2 {
+		return "", fmt.Errorf("incorrect hash format")
+	}
+
+	rounds := -1
+	if strings.HasPrefix(settings[0], roundsPrefix) {
+		if len(settings) < 3 {
+			return "", fmt.Errorf("incorrect hash format")
+		}
+		if _, err := fmt.Sscanf(settings[0][len(roundsPrefix):], "%d", &rounds); err != nil {
+			return "", fmt.Errorf("incorrect hash rounds; details: %s", err.Error())
+		}
+		settings = settings[1:]
+	}
+
+	if prefix == prefixSHA256 {
+		return SHA256(password, []byte(settings[0]), rounds), nil
+	}
+	return SHA512(password, []byte(settings[0]), rounds), nil
+}
+
+// itoa64 is the alphabet of the crypt base64 encoding.
+const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// appendBase64 appends n characters of the crypt base64 encoding of the 24 bits of the three bytes.
+func appendBase64(b []byte, b2, b1, b0 byte, n int) []byte {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+	for ; n > 0; n-- {
+		b = append(b, itoa64[w&0x3f])
+		w >>= 6
+	}
+	return b
+}
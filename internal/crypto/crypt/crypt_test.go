@@ -0,0 +1,63 @@
+package crypt
+
+import (
+	"errors"
+	"testing"
+)
+
+// the vectors are created by "openssl passwd" and the crypt(3) of glibc
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		password string
+		hash     string
+	}{
+		{"secret", "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="},
+		{"Hello world!", "$apr1$saltstr$657VxpqLCAXXzVs.p3YvD/"},
+		{"", "$apr1$x$tMwYqBfQwi3FYAr0aJc8M/"},
+		{"Hello world!", "$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5"},
+		{"Hello world!", "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"},
+		{"Hello world!", "$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA"},
+		{"Hello world!", "$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v."},
+		{"", "$6$empty$MWslJBrCvUsbDfvDkNQwBNtJFEGiZ5CHosSR8Ol/yMiSd9JINPGkSH4OfOOVEIp87YcT49Wr.Qp4a8bJCR6y2/"},
+		{"pass:word with spaces and a very long tail to exceed sixty four bytes in length ok", "$6$short$9UU7TbprQVce.RBuIlpQfbax8tbdP52Jplh22H6HajAllQCQFd97rAfgWoRqNwboAnsDngWHPMlTZU7v8Jryt1"},
+	}
+	for _, tt := range tests {
+		ok, err := Verify([]byte(tt.password), tt.hash)
+		if err != nil {
+			t.Errorf("%s: %s", tt.hash, err.Error())
+		}
+		if !ok {
+			t.Errorf("%s: incorrect verification of the password", tt.hash)
+		}
+		ok, err = Verify([]byte(tt.password+"x"), tt.hash)
+		if err != nil {
+			t.Errorf("%s: %s", tt.hash, err.Error())
+		}
+		if ok {
+			t.Errorf("%s: incorrect verification of the wrong password", tt.hash)
+		}
+	}
+}
+
+func TestVerify_Unsupported(t *testing.T) {
+	for _, hash := range []string{"$2y$10$abcdefghijklmnopqrstuu", "plain", "$6$", "$5$rounds=x$salt$hash"} {
+		if ok, err := Verify([]byte("plain"), hash); ok || err == nil {
+			t.Errorf("%s: incorrect verification, got %v, %v", hash, ok, err)
+		}
+	}
+	if _, err := Verify([]byte("plain"), "plain"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("incorrect error, got %v", err)
+	}
+	if Supported("$2y$10$abcdefghijklmnopqrstuu") || !Supported("$apr1$salt$hash") {
+		t.Errorf("incorrect supported schemes")
+	}
+}
+
+func TestSHA256_Rounds(t *testing.T) {
+	if h := SHA256([]byte("password"), []byte("salt"), 10); h[:19] != "$5$rounds=1000$salt" {
+		t.Errorf("incorrect rounds, got %s", h)
+	}
+	if h := SHA512([]byte("password"), []byte("salt"), -1); h[:8] != "$6$salt$" {
+		t.Errorf("incorrect default rounds, got %s", h)
+	}
+}
@@ -0,0 +1,159 @@
+package crypt
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"strconv"
+)
+
+// the parameters of SHA-crypt (https://www.akkadia.org/drepper/SHA-crypt.txt)
+const (
+	roundsPrefix       = "rounds="
+	roundsDefault      = 5000
+	roundsMin          = 1000
+	roundsMax          = 999999999
+	maxSHASaltLength   = 16
+	sha256PermutedSize = 43
+	sha512PermutedSize = 86
+)
+
+// sha256Permutation and sha512Permutation are the orders of the bytes of the digest in the encoded hash,
+// each triple is encoded into four characters.
+var (
+	sha256Permutation = [][3]int{
+		{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+		{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29},
+	}
+	sha512Permutation = [][3]int{
+		{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4}, {47, 5, 26}, {6, 27, 48},
+		{28, 49, 7}, {50, 8, 29}, {9, 30, 51}, {31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13},
+		{56, 14, 35}, {15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19}, {62, 20, 41},
+	}
+)
+
+// SHA256 computes the SHA-256 crypt hash of the password in the format "$5$rounds=N$salt$hash",
+// the rounds are omitted if they are negative, which means the default number of rounds.
+func SHA256(password []byte, salt []byte, rounds int) string {
+	sum, salt, rounds, custom := shaCrypt(sha256.New, password, salt, rounds)
+
+	b := appendSettings(make([]byte, 0, 32+len(salt)+sha256PermutedSize), prefixSHA256, salt, rounds, custom)
+	for _, p := range sha256Permutation {
+		b = appendBase64(b, sum[p[0]], sum[p[1]], sum[p[2]], 4)
+	}
+	b = appendBase64(b, 0, sum[31], sum[30], 3)
+
+	return string(b)
+}
+
+// SHA512 computes the SHA-512 crypt hash of the password in the format "$6$rounds=N$salt$hash",
+// the rounds are omitted if they are negative, which means the default number of rounds.
+func SHA512(password []byte, salt []byte, rounds int) string {
+	sum, salt, rounds, custom := shaCrypt(sha512.New, password, salt, rounds)
+
+	b := appendSettings(make([]byte, 0, 32+len(salt)+sha512PermutedSize), prefixSHA512, salt, rounds, custom)
+	for _, p := range sha512Permutation {
+		b = appendBase64(b, sum[p[0]], sum[p[1]], sum[p[2]], 4)
+	}
+	b = appendBase64(b, 0, 0, sum[63], 2)
+
+	return string(b)
+}
+
+// appendSettings appends the prefix, the rounds (if custom) and the salt of the hash.
+func appendSettings(b []byte, prefix string, salt []byte, rounds int, custom bool) []byte {
+	b = append(b, prefix...)
+	if custom {
+		b = append(b, roundsPrefix...)
+		b = strconv.AppendInt(b, int64(rounds), 10)
+		b = append(b, '$')
+	}
+	b = append(b, salt...)
+	return append(b, '$')
+}
+
+// shaCrypt computes the digest of SHA-crypt, the salt is truncated and the rounds are clamped like in the specification.
+func shaCrypt(newHash func() hash.Hash, password []byte, salt []byte, rounds int) (sum []byte, truncatedSalt []byte, clampedRounds int, custom bool) {
+	if len(salt) > maxSHASaltLength {
+		salt = salt[:maxSHASaltLength]
+	}
+	custom = rounds >= 0
+	switch {
+	case !custom:
+		rounds = roundsDefault
+	case rounds < roundsMin:
+		rounds = roundsMin
+	case rounds > roundsMax:
+		rounds = roundsMax
+	}
+
+	// digest B
+	h := newHash()
+	h.Write(password)
+	h.Write(salt)
+	h.Write(password)
+	altSum := h.Sum(nil)
+	size := len(altSum)
+
+	// digest A
+	h = newHash()
+	h.Write(password)
+	h.Write(salt)
+	for i := len(password); i > 0; i -= size {
+		h.Write(altSum[:min(i, size)])
+	}
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write(altSum)
+		} else {
+			h.Write(password)
+		}
+	}
+	sum = h.Sum(nil)
+
+	// byte sequence P
+	h = newHash()
+	for i := 0; i < len(password); i++ {
+		h.Write(password)
+	}
+	p := repeat(h.Sum(nil), len(password))
+
+	// byte sequence S
+	h = newHash()
+	for i := 0; i < 16+int(sum[0]); i++ {
+		h.Write(salt)
+	}
+	s := repeat(h.Sum(nil), len(salt))
+
+	for i := 0; i < rounds; i++ {
+		h = newHash()
+		if i&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(sum)
+		}
+		if i%3 != 0 {
+			h.Write(s)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i&1 != 0 {
+			h.Write(sum)
+		} else {
+			h.Write(p)
+		}
+		sum = h.Sum(nil)
+	}
+
+	return sum, salt, rounds, custom
+}
+
+// repeat repeats the digest up to the length.
+func repeat(digest []byte, l int) []byte {
+	b := make([]byte, 0, l)
+	for len(b) < l {
+		b = append(b, digest[:min(l-len(b), len(digest))]...)
+	}
+	return b
+}
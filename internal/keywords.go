@@ -1,8 +1,6 @@
 package internal
 
 const (
-	ContextKeyToken = "CONTEXT_TOKEN_E2F313260669495F9D5CC67E0BD98128"
-
 	AuthMethodBasic  = "Basic"
 	AuthMethodBearer = "Bearer"
 
@@ -0,0 +1,271 @@
+package tokeninjector
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"github.com/prorochestvo/tokeninjector/internal"
+	"net/http"
+	"strings"
+)
+
+// Authenticator extracts the token from the request, e.g. from the cookie or a custom header.
+// It returns nil token and nil error if the request does not carry its credential,
+// so the next authenticator of the chain is tried. The name recorded by AuthenticatorHandler
+// is the result of String if the authenticator implements fmt.Stringer.
+type Authenticator interface {
+	Authenticate(r *http.Request) (Token, error)
+}
+
+// TokenDecoder decodes and validates the token string extracted by the built-in authenticators.
+type TokenDecoder func(data string) (Token, error)
+
+// KeyRingDecoder creates the decoder of the tokens created by MarshalWithKeyRing, see UnmarshalToken.
+func KeyRingDecoder(ring *KeyRing, opts ...ValidateOption) (TokenDecoder, error) {
+	if ring == nil {
+		return nil, errors.New("key ring is not defined")
+	}
+	return func(data string) (Token, error) {
+		return UnmarshalToken(data, ring, opts...)
+	}, nil
+}
+
+// PublicKeyDecoder creates the decoder of the tokens created by MarshalSigned, see UnmarshalSigned.
+func PublicKeyDecoder(publicKey crypto.PublicKey, opts ...ValidateOption) (TokenDecoder, error) {
+	key, err := ed25519PublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	validation := newValidateOptions(opts)
+	return func(data string) (Token, error) {
+		t, err := unmarshalSignedToken(data, key)
+		if err == nil {
+			err = validateToken(t, validation)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	}, nil
+}
+
+// JWTDecoder creates the decoder of the JWTs created by MarshalJWT, see UnmarshalJWT.
+//   - key: the secret key ([]byte) for HS algorithms or the public key (ed25519.PublicKey) for EdDSA.
+func JWTDecoder(alg string, key any, opts ...ValidateOption) (TokenDecoder, error) {
+	verifier, err := newJWTSigner(alg, key, false)
+	if err != nil {
+		return nil, err
+	}
+	validation := newValidateOptions(opts)
+	return func(data string) (Token, error) {
+		t, err := unmarshalJWTToken(data, verifier)
+		if err == nil {
+			err = validateToken(t, validation)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	}, nil
+}
+
+// AuthenticatorHandler is a middleware that tries the authenticators in order and adds the token
+// of the first successful one to the request context, see ExtractToken and AuthenticatedBy.
+// If no authenticator succeeds, the first error is the reason returned by ExtractToken.
+// The options WithRequireToken, WithErrorResponder and the validation options (e.g. WithValidation,
+// WithRevocationStore) apply to this middleware, the validation runs on the token of every authenticator.
+// The other options configure the token sources of TokenHandler and are rejected with an error.
+func AuthenticatorHandler(authenticators []Authenticator, nextFunc http.HandlerFunc, opts ...HandlerOption) (http.HandlerFunc, error) {
+	if len(authenticators) == 0 {
+		return nil, errors.New("authenticators are not defined")
+	}
+	for i, a := range authenticators {
+		if a == nil {
+			return nil, fmt.Errorf("authenticator %d is not defined", i)
+		}
+		if c, ok := a.(interface{ check() error }); ok {
+			if err := c.check(); err != nil {
+				return nil, fmt.Errorf("authenticator %d (%s) is invalid; details: %s", i, authenticatorName(a), err.Error())
+			}
+		}
+	}
+	options := newHandlerOptions(opts)
+	switch {
+	case options.reissueCookie != nil || options.sliding != nil || options.cookie != nil:
+		return nil, errors.New("cookie reissue and sliding expiration are not supported by the authenticators")
+	case options.jwtBearer || options.bearerToken || options.basicVerifier != nil || options.precedence != PreferCookie:
+		return nil, errors.New("token sources are defined by the authenticators, the options of TokenHandler are not supported")
+	}
+	validation := newValidateOptions(options.validation)
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if options.responder != nil {
+			ctx = contextWithResponder(ctx, options.responder)
+		}
+
+		var rejection error
+		for _, a := range authenticators {
+			t, err := a.Authenticate(r)
+			if err == nil && t != nil {
+				err = validateToken(tokenOf(t), validation)
+			}
+			if err != nil {
+				if rejection == nil {
+					rejection = err
+				}
+				continue
+			}
+			if t != nil {
+				ctx = WithToken(ctx, t)
+				ctx = context.WithValue(ctx, contextKeyAuthenticator{}, authenticatorName(a))
+				rejection = nil
+				break
+			}
+		}
+		if rejection != nil {
+			ctx = withTokenError(ctx, rejection)
+		}
+
+		if options.requireToken {
+			RequireToken(nextFunc)(w, r.WithContext(ctx))
+			return
+		}
+
+		nextFunc(w, r.WithContext(ctx))
+	}, nil
+}
+
+// AuthenticatedBy returns the name of the authenticator that extracted the token of the request,
+// it is set only by AuthenticatorHandler.
+func AuthenticatedBy(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(contextKeyAuthenticator{}).(string)
+	return name, ok
+}
+
+// authenticatorName returns the name of the authenticator: the result of String or the type.
+func authenticatorName(a Authenticator) string {
+	if s, ok := a.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", a)
+}
+
+// CookieAuthenticator creates the authenticator of the token stored in the cookie with the name.
+func CookieAuthenticator(cookieName string, decode TokenDecoder) Authenticator {
+	return &sourceAuthenticator{kind: "cookie", name: cookieName, decode: decode, extract: func(r *http.Request) string {
+		if c, err := r.Cookie(cookieName); err == nil {
+			return c.Value
+		}
+		return ""
+	}}
+}
+
+// BearerAuthenticator creates the authenticator of the token sent in the "Authorization: Bearer" header.
+func BearerAuthenticator(decode TokenDecoder) Authenticator {
+	return &sourceAuthenticator{kind: "bearer", decode: decode, extract: func(r *http.Request) string {
+		method, credential := parseAuthorization(r)
+		if !strings.EqualFold(method, internal.AuthMethodBearer) {
+			return ""
+		}
+		return credential
+	}}
+}
+
+// BasicAuthenticator creates the authenticator of the username and password sent in the "Authorization: Basic" header,
+// they are verified by the verifier.
+func BasicAuthenticator(verifier CredentialVerifier) Authenticator {
+	return &basicAuthenticator{verifier: verifier}
+}
+
+// basicAuthenticator is the authenticator of BasicAuthenticator.
+type basicAuthenticator struct {
+	verifier CredentialVerifier
+}
+
+// Authenticate verifies the credentials of the Basic method.
+func (a *basicAuthenticator) Authenticate(r *http.Request) (Token, error) {
+	method, credential := parseAuthorization(r)
+	if !strings.EqualFold(method, internal.AuthMethodBasic) || len(credential) == 0 {
+		return nil, nil
+	}
+	return verifyBasicCredentials(credential, a.verifier)
+}
+
+// String returns the kind of the authenticator.
+func (a *basicAuthenticator) String() string { return "basic" }
+
+// check reports the authenticator created without the verifier.
+func (a *basicAuthenticator) check() error {
+	if a.verifier == nil {
+		return errors.New("credential verifier is not defined")
+	}
+	return nil
+}
+
+// QueryAuthenticator creates the authenticator of the token sent in the query parameter with the name.
+// The query is often logged by proxies, so it should be used only for short-lived tokens (e.g. of the WebSocket).
+func QueryAuthenticator(param string, decode TokenDecoder) Authenticator {
+	return &sourceAuthenticator{kind: "query", name: param, decode: decode, extract: func(r *http.Request) string {
+		return r.URL.Query().Get(param)
+	}}
+}
+
+// HeaderAuthenticator creates the authenticator of the token sent in the custom header with the name, e.g. X-API-Key.
+func HeaderAuthenticator(header string, decode TokenDecoder) Authenticator {
+	return &sourceAuthenticator{kind: "header", name: header, decode: decode, extract: func(r *http.Request) string {
+		return r.Header.Get(header)
+	}}
+}
+
+// FormAuthenticator creates the authenticator of the token sent in the field of the POST, PUT or PATCH form.
+// The request body is consumed to parse the form, the next handler reads the form fields
+// from r.PostForm or r.Form instead of the body.
+func FormAuthenticator(field string, decode TokenDecoder) Authenticator {
+	return &sourceAuthenticator{kind: "form", name: field, decode: decode, extract: func(r *http.Request) string {
+		return r.PostFormValue(field)
+	}}
+}
+
+// sourceAuthenticator is the built-in authenticator of the token string extracted from the request.
+type sourceAuthenticator struct {
+	kind    string
+	name    string
+	decode  TokenDecoder
+	extract func(r *http.Request) string
+}
+
+// Authenticate decodes the token string extracted from the request.
+func (a *sourceAuthenticator) Authenticate(r *http.Request) (Token, error) {
+	data := strings.TrimSpace(a.extract(r))
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return a.decode(data)
+}
+
+// check reports the authenticator created without the decoder.
+func (a *sourceAuthenticator) check() error {
+	if a.decode == nil {
+		return errors.New("token decoder is not defined")
+	}
+	return nil
+}
+
+// String returns the kind and the name of the source, e.g. "cookie:session".
+func (a *sourceAuthenticator) String() string {
+	if len(a.name) == 0 {
+		return a.kind
+	}
+	return a.kind + ":" + a.name
+}
+
+// parseAuthorization splits the Authorization header into the method and the credential.
+func parseAuthorization(r *http.Request) (method string, credential string) {
+	header := strings.TrimSpace(r.Header.Get(internal.HeaderAuthorization))
+	if parts := strings.SplitN(header, " ", 2); len(parts) == 2 {
+		method = parts[0]
+		credential = strings.TrimSpace(parts[1])
+	}
+	return
+}
@@ -0,0 +1,236 @@
+package tokeninjector
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"github.com/prorochestvo/tokeninjector/internal"
+	"github.com/twinj/uuid"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthenticatorHandler(t *testing.T) {
+	ring, err := newSingleKeyRing(uuid.NewV4().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	decode, err := KeyRingDecoder(ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID := uuid.NewV4().String()
+	value, err := MarshalWithKeyRing(userID, uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Hour), ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiredValue, err := MarshalWithKeyRing(userID, uuid.NewV4().String(), rand.Uint64(), time.Now().Add(-time.Hour), ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var authenticatedBy string
+	var tokenErr error
+	h, err := AuthenticatorHandler([]Authenticator{
+		CookieAuthenticator("session", decode),
+		BearerAuthenticator(decode),
+		HeaderAuthenticator("X-API-Key", decode),
+		QueryAuthenticator("access_token", decode),
+		FormAuthenticator("token", decode),
+		&apiKeyAuthenticator{key: "secret", userID: userID},
+	}, func(w http.ResponseWriter, r *http.Request) {
+		authenticatedBy, _ = AuthenticatedBy(r.Context())
+		v, err := ExtractToken(r.Context())
+		if tokenErr = err; err != nil {
+			return
+		}
+		_, _ = w.Write([]byte(v.UserID()))
+	})
+	if err != nil {
+		t.Fatalf("could not create nextHandler; details: %s", err.Error())
+	}
+
+	tests := []struct {
+		name     string
+		request  func() *http.Request
+		expected string
+		err      error
+	}{
+		{"cookie:session", func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.AddCookie(&http.Cookie{Name: "session", Value: value})
+			return req
+		}, "cookie:session", nil},
+		{"bearer", func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(internal.HeaderAuthorization, "bearer "+value)
+			return req
+		}, "bearer", nil},
+		{"header:X-API-Key", func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-API-Key", value)
+			return req
+		}, "header:X-API-Key", nil},
+		{"query:access_token", func() *http.Request {
+			return httptest.NewRequest(http.MethodGet, "/?access_token="+url.QueryEscape(value), nil)
+		}, "query:access_token", nil},
+		{"form:token", func() *http.Request {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("token="+url.QueryEscape(value)))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			return req
+		}, "form:token", nil},
+		{"custom", func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Service-Key", "secret")
+			return req
+		}, "api-key", nil},
+		{"expired cookie, valid query", func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, "/?access_token="+url.QueryEscape(value), nil)
+			req.AddCookie(&http.Cookie{Name: "session", Value: expiredValue})
+			return req
+		}, "query:access_token", nil},
+		{"expired cookie", func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.AddCookie(&http.Cookie{Name: "session", Value: expiredValue})
+			return req
+		}, "", ErrExpired},
+		{"nothing", func() *http.Request {
+			return httptest.NewRequest(http.MethodGet, "/", nil)
+		}, "", ErrTokenNotFound},
+	}
+	for _, tt := range tests {
+		authenticatedBy, tokenErr = "", nil
+		res := httptest.NewRecorder()
+
+		h(res, tt.request())
+		if authenticatedBy != tt.expected {
+			t.Errorf("%s: incorrect authenticator, got %s", tt.name, authenticatedBy)
+		}
+		if tt.err != nil && !errors.Is(tokenErr, tt.err) {
+			t.Errorf("%s: incorrect error, got %v", tt.name, tokenErr)
+		}
+		if tt.err == nil && res.Body.String() != userID {
+			t.Errorf("%s: incorrect response body, got %s", tt.name, res.Body.String())
+		}
+	}
+}
+
+func TestAuthenticatorHandler_RequireToken(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decode, err := PublicKeyDecoder(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := MarshalSigned(uuid.NewV4().String(), uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Hour), privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := AuthenticatorHandler([]Authenticator{BearerAuthenticator(decode)}, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}, WithRequireToken())
+	if err != nil {
+		t.Fatalf("could not create nextHandler; details: %s", err.Error())
+	}
+
+	for credential, code := range map[string]int{value: http.StatusOK, "": http.StatusUnauthorized, "x" + value: http.StatusUnauthorized} {
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(internal.HeaderAuthorization, fmt.Sprintf("%s %s", internal.AuthMethodBearer, credential))
+
+		h(res, req)
+		if res.Code != code {
+			t.Errorf("incorrect response code, got %d, expected %d", res.Code, code)
+		}
+	}
+
+	if _, err = AuthenticatorHandler(nil, func(w http.ResponseWriter, r *http.Request) {}); err == nil {
+		t.Errorf("incorrect handler, the empty chain is accepted")
+	}
+	if _, err = AuthenticatorHandler([]Authenticator{CookieAuthenticator("session", nil)}, func(w http.ResponseWriter, r *http.Request) {}); err == nil {
+		t.Errorf("incorrect handler, the authenticator without the decoder is accepted")
+	}
+	if _, err = AuthenticatorHandler([]Authenticator{BasicAuthenticator(nil)}, func(w http.ResponseWriter, r *http.Request) {}); err == nil {
+		t.Errorf("incorrect handler, the authenticator without the verifier is accepted")
+	}
+}
+
+func TestAuthenticatorHandler_Validation(t *testing.T) {
+	ring, err := newSingleKeyRing(uuid.NewV4().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	decode, err := KeyRingDecoder(ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryRevocationStore()
+	tkn := NewToken(uuid.NewV4().String(), uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Hour))
+	value, err := marshalToken(tokenOf(tkn), ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tokenErr error
+	h, err := AuthenticatorHandler([]Authenticator{BearerAuthenticator(decode)}, func(w http.ResponseWriter, r *http.Request) {
+		_, tokenErr = ExtractToken(r.Context())
+	}, WithRevocationStore(store))
+	if err != nil {
+		t.Fatalf("could not create nextHandler; details: %s", err.Error())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(internal.HeaderAuthorization, fmt.Sprintf("%s %s", internal.AuthMethodBearer, value))
+	h(httptest.NewRecorder(), req)
+	if tokenErr != nil {
+		t.Errorf("incorrect error of the valid token, got %v", tokenErr)
+	}
+
+	if err = RevokeToken(store, tkn); err != nil {
+		t.Fatal(err)
+	}
+	h(httptest.NewRecorder(), req)
+	if !errors.Is(tokenErr, ErrRevoked) {
+		t.Errorf("incorrect error of the revoked token, got %v", tokenErr)
+	}
+
+	for name, opt := range map[string]HandlerOption{
+		"sliding":  WithSlidingExpiration(time.Minute, time.Hour, 0),
+		"reissue":  WithCookieReissue(http.Cookie{}),
+		"bearer":   WithBearerToken(),
+		"jwt":      WithJWTBearer(JWTAlgorithmHS256, uuid.NewV4().Bytes()),
+		"basic":    WithBasicVerifier(NewMemoryVerifier()),
+		"conflict": WithTokenPrecedence(RejectConflict),
+	} {
+		if _, err = AuthenticatorHandler([]Authenticator{BearerAuthenticator(decode)}, nil, opt); err == nil {
+			t.Errorf("%s: unsupported option was accepted", name)
+		}
+	}
+}
+
+// apiKeyAuthenticator is the custom authenticator of the static service key.
+type apiKeyAuthenticator struct {
+	key    string
+	userID string
+}
+
+func (a *apiKeyAuthenticator) Authenticate(r *http.Request) (Token, error) {
+	key := r.Header.Get("X-Service-Key")
+	if len(key) == 0 {
+		return nil, nil
+	}
+	if key != a.key {
+		return nil, newTokenError(ErrTampered, "incorrect service key")
+	}
+	return &token{userID: a.userID, expiredAt: time.Now().Add(time.Minute)}, nil
+}
+
+func (a *apiKeyAuthenticator) String() string { return "api-key" }
@@ -0,0 +1,275 @@
+package tokeninjector
+
+import (
+	"encoding/binary"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ClaimTag is the tag that identifies a claim in the claims section of the token.
+// The tags from ClaimTagReserved and above are reserved for the claims of the package.
+type ClaimTag uint16
+
+// ClaimTagReserved is the first tag reserved for the claims of the package.
+const ClaimTagReserved ClaimTag = 0xFF00
+
+// tags of the registered claims, they are stored in the claims section and exposed by the methods of Token.
+const (
+	claimTagIssuedAt = ClaimTagReserved + iota
+	claimTagNotBefore
+	claimTagIssuer
+	claimTagAudience
+	claimTagTokenID
+	claimTagScopes
+	claimTagGeneration
+)
+
+// claimType is the type of the claim value, it is stored in the claims section together with the tag.
+type claimType uint8
+
+const (
+	claimTypeString claimType = iota + 1
+	claimTypeInt
+	claimTypeBool
+	claimTypeBytes
+)
+
+// claimsSectionMarker is the first byte of the claims section, which follows the fixed fields of the payload.
+const claimsSectionMarker = 'C'
+
+// Claims is a set of additional claims of the token, each claim is identified by a tag.
+// The zero value is an empty set.
+type Claims struct {
+	values map[ClaimTag]claim
+}
+
+// claim is a raw claim value with its type.
+type claim struct {
+	kind  claimType
+	value []byte
+}
+
+// Tags returns the tags of all claims in ascending order.
+func (c Claims) Tags() []ClaimTag {
+	tags := make([]ClaimTag, 0, len(c.values))
+	for tag := range c.values {
+		tags = append(tags, tag)
+	}
+	slices.Sort(tags)
+	return tags
+}
+
+// Has reports whether the claim with the tag exists.
+func (c Claims) Has(tag ClaimTag) bool {
+	_, ok := c.values[tag]
+	return ok
+}
+
+// String returns the string value of the claim with the tag.
+func (c Claims) String(tag ClaimTag) (string, bool) {
+	v, ok := c.values[tag]
+	if !ok || v.kind != claimTypeString {
+		return "", false
+	}
+	return string(v.value), true
+}
+
+// Int returns the integer value of the claim with the tag.
+func (c Claims) Int(tag ClaimTag) (int64, bool) {
+	v, ok := c.values[tag]
+	if !ok || v.kind != claimTypeInt || len(v.value) != 8 {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint64(v.value)), true
+}
+
+// time returns the value of the integer claim with the tag as unix time.
+func (c Claims) time(tag ClaimTag) time.Time {
+	if v, ok := c.Int(tag); ok {
+		return time.Unix(v, 0).UTC()
+	}
+	return time.Time{}
+}
+
+// Bool returns the boolean value of the claim with the tag.
+func (c Claims) Bool(tag ClaimTag) (bool, bool) {
+	v, ok := c.values[tag]
+	if !ok || v.kind != claimTypeBool || len(v.value) != 1 {
+		return false, false
+	}
+	return v.value[0] != 0, true
+}
+
+// Bytes returns the binary value of the claim with the tag.
+func (c Claims) Bytes(tag ClaimTag) ([]byte, bool) {
+	v, ok := c.values[tag]
+	if !ok || v.kind != claimTypeBytes {
+		return nil, false
+	}
+	return slices.Clone(v.value), true
+}
+
+// set sets the raw value of the claim with the tag.
+func (c *Claims) set(tag ClaimTag, kind claimType, value []byte) {
+	if c.values == nil {
+		c.values = make(map[ClaimTag]claim)
+	}
+	c.values[tag] = claim{kind: kind, value: value}
+}
+
+// setInt sets the integer value of the claim with the tag.
+func (c *Claims) setInt(tag ClaimTag, value int64) {
+	c.set(tag, claimTypeInt, binary.BigEndian.AppendUint64(nil, uint64(value)))
+}
+
+// setTime sets the value of the integer claim with the tag to unix time, zero time is not stored.
+func (c *Claims) setTime(tag ClaimTag, value time.Time) {
+	if !value.IsZero() {
+		c.setInt(tag, value.Unix())
+	}
+}
+
+// setString sets the string value of the claim with the tag, an empty string is not stored.
+func (c *Claims) setString(tag ClaimTag, value string) {
+	if len(value) > 0 {
+		c.set(tag, claimTypeString, []byte(value))
+	}
+}
+
+// withRegisteredClaims returns the claims of the token together with its registered claims.
+func withRegisteredClaims(t *token) Claims {
+	c := Claims{values: maps.Clone(t.claims.values)}
+	c.setTime(claimTagIssuedAt, t.issuedAt)
+	c.setTime(claimTagNotBefore, t.notBefore)
+	c.setString(claimTagIssuer, t.issuer)
+	c.setString(claimTagAudience, t.audience)
+	c.setString(claimTagTokenID, t.tokenID)
+	c.setString(claimTagScopes, strings.Join(t.scopes, " "))
+	if t.generation > 0 {
+		c.setInt(claimTagGeneration, int64(t.generation))
+	}
+	return c
+}
+
+// extractRegisteredClaims moves the registered claims from the claims of the token to its fields.
+func extractRegisteredClaims(t *token) {
+	t.issuedAt = t.claims.time(claimTagIssuedAt)
+	t.notBefore = t.claims.time(claimTagNotBefore)
+	t.issuer, _ = t.claims.String(claimTagIssuer)
+	t.audience, _ = t.claims.String(claimTagAudience)
+	t.tokenID, _ = t.claims.String(claimTagTokenID)
+	if generation, ok := t.claims.Int(claimTagGeneration); ok {
+		t.generation = uint64(generation)
+	}
+	if scopes, ok := t.claims.String(claimTagScopes); ok {
+		t.scopes = strings.Fields(scopes)
+	}
+	for tag := range t.claims.values {
+		if tag >= ClaimTagReserved {
+			delete(t.claims.values, tag)
+		}
+	}
+}
+
+// WithStringClaim adds the string claim with the tag to the token.
+func WithStringClaim(tag ClaimTag, value string) MarshalOption {
+	return func(t *token) {
+		t.claims.set(tag, claimTypeString, []byte(value))
+	}
+}
+
+// WithIntClaim adds the integer claim with the tag to the token.
+func WithIntClaim(tag ClaimTag, value int64) MarshalOption {
+	return func(t *token) {
+		t.claims.setInt(tag, value)
+	}
+}
+
+// WithBoolClaim adds the boolean claim with the tag to the token.
+func WithBoolClaim(tag ClaimTag, value bool) MarshalOption {
+	return func(t *token) {
+		b := []byte{0}
+		if value {
+			b[0] = 1
+		}
+		t.claims.set(tag, claimTypeBool, b)
+	}
+}
+
+// WithBytesClaim adds the binary claim with the tag to the token.
+func WithBytesClaim(tag ClaimTag, value []byte) MarshalOption {
+	return func(t *token) {
+		t.claims.set(tag, claimTypeBytes, slices.Clone(value))
+	}
+}
+
+// encodeClaims creates the claims section, every entry is the tag (2 bytes), the type (1 byte),
+// the length of the value (varint) and the value. An empty set produces no section.
+func encodeClaims(c Claims) ([]byte, error) {
+	if len(c.values) == 0 {
+		return nil, nil
+	}
+
+	l := 1
+	for _, v := range c.values {
+		l += 3 + binary.MaxVarintLen64 + len(v.value)
+	}
+
+	b := make([]byte, 0, l)
+	b = append(b, claimsSectionMarker)
+	for _, tag := range c.Tags() {
+		v := c.values[tag]
+		b = append(b, uint8(tag>>8), uint8(tag), uint8(v.kind))
+		b = binary.AppendUvarint(b, uint64(len(v.value)))
+		b = append(b, v.value...)
+	}
+
+	return b, nil
+}
+
+// decodeClaims extracts the claims from the claims section.
+// Entries with an unknown type are skipped, so the section can be extended by new value types.
+func decodeClaims(data []byte) (c Claims, err error) {
+	if len(data) == 0 {
+		return
+	}
+	if data[0] != claimsSectionMarker {
+		err = fmt.Errorf("incorrect claims section")
+		return
+	}
+
+	for l := 1; l < len(data); {
+		if len(data)-l < 4 {
+			err = fmt.Errorf("incorrect claims section size")
+			return
+		}
+		tag := ClaimTag(data[l])<<8 | ClaimTag(data[l+1])
+		kind := claimType(data[l+2])
+		l += 3
+
+		lValue, n := binary.Uvarint(data[l:])
+		if n <= 0 || lValue > uint64(len(data)-l-n) {
+			err = fmt.Errorf("incorrect claims section size")
+			return
+		}
+		l += n
+		value := data[l : l+int(lValue)]
+		l += int(lValue)
+
+		switch kind {
+		case claimTypeString, claimTypeInt, claimTypeBool, claimTypeBytes:
+		default:
+			continue
+		}
+		if c.Has(tag) {
+			err = fmt.Errorf("duplicate claim %d", tag)
+			return
+		}
+		c.set(tag, kind, slices.Clone(value))
+	}
+
+	return
+}
@@ -0,0 +1,120 @@
+package tokeninjector
+
+import (
+	"bytes"
+	"github.com/twinj/uuid"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestMarshal_Claims(t *testing.T) {
+	const (
+		tagTenant ClaimTag = iota + 1
+		tagQuota
+		tagBeta
+		tagFingerprint
+	)
+	expectedTenant := uuid.NewV4().String()
+	expectedQuota := -rand.Int63()
+	expectedFingerprint := uuid.NewV4().Bytes()
+	secretKey := uuid.NewV4().Bytes()
+
+	dataset, err := Marshal(uuid.NewV4().String(), uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Hour), secretKey,
+		WithStringClaim(tagTenant, expectedTenant),
+		WithIntClaim(tagQuota, expectedQuota),
+		WithBoolClaim(tagBeta, true),
+		WithBytesClaim(tagFingerprint, expectedFingerprint),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ring, err := newSingleKeyRing(secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tkn, err := UnmarshalToken(dataset, ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := tkn.Claims()
+
+	if tags := claims.Tags(); len(tags) != 4 {
+		t.Fatalf("incorrect claims, got %v", tags)
+	}
+	if v, ok := claims.String(tagTenant); !ok || v != expectedTenant {
+		t.Errorf("incorrect string claim, got %s, expected %s", v, expectedTenant)
+	}
+	if v, ok := claims.Int(tagQuota); !ok || v != expectedQuota {
+		t.Errorf("incorrect int claim, got %d, expected %d", v, expectedQuota)
+	}
+	if v, ok := claims.Bool(tagBeta); !ok || !v {
+		t.Errorf("incorrect bool claim, got %t", v)
+	}
+	if v, ok := claims.Bytes(tagFingerprint); !ok || !bytes.Equal(v, expectedFingerprint) {
+		t.Errorf("incorrect bytes claim, got %X, expected %X", v, expectedFingerprint)
+	}
+	if _, ok := claims.Int(tagTenant); ok {
+		t.Errorf("string claim was returned as int")
+	}
+	if claims.Has(tagFingerprint + 1) {
+		t.Errorf("unknown claim was found")
+	}
+}
+
+func TestMarshal_LongClaim(t *testing.T) {
+	expectedValue := bytes.Repeat([]byte{'x'}, 0x10000+1)
+	ring, err := newSingleKeyRing(uuid.NewV4().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataset, err := MarshalWithKeyRing("id", "name", 0, time.Now().Add(time.Hour), ring, WithBytesClaim(1, expectedValue))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tkn, err := UnmarshalToken(dataset, ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := tkn.Claims().Bytes(1); !ok || !bytes.Equal(v, expectedValue) {
+		t.Errorf("incorrect bytes claim, got %d bytes, expected %d", len(v), len(expectedValue))
+	}
+}
+
+func TestMarshal_ClaimsErrors(t *testing.T) {
+	secretKey := uuid.NewV4().Bytes()
+
+	if _, err := Marshal("id", "name", 0, time.Now(), secretKey, WithStringClaim(ClaimTagReserved, "x")); err == nil {
+		t.Errorf("reserved claim tag was accepted")
+	}
+}
+
+func TestDecodeClaims_UnknownType(t *testing.T) {
+	var c Claims
+	c.set(1, claimTypeString, []byte("first"))
+	c.set(3, claimType(0x7F), []byte("unknown"))
+	c.set(5, claimTypeString, []byte("last"))
+
+	data, err := encodeClaims(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := decodeClaims(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual.Has(3) {
+		t.Errorf("claim of the unknown type was not skipped")
+	}
+	if v, ok := actual.String(5); !ok || v != "last" {
+		t.Errorf("claim after the unknown type was lost, got %s", v)
+	}
+
+	if _, err = decodeClaims(data[:len(data)-1]); err == nil {
+		t.Errorf("truncated claims section was accepted")
+	}
+}
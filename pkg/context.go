@@ -0,0 +1,65 @@
+package tokeninjector
+
+import (
+	"context"
+)
+
+// The keys of the values added to the request context by the middleware,
+// the types are unexported, so the keys do not collide with the keys of other packages.
+type (
+	contextKeyToken          struct{}
+	contextKeyTokenError     struct{}
+	contextKeyBasic          struct{}
+	contextKeyBearer         struct{}
+	contextKeyErrorResponder struct{}
+	contextKeyAuthenticator  struct{}
+)
+
+// WithToken returns a copy of the context with the token, which is returned by ExtractToken.
+// It lets the code outside of the HTTP handlers (e.g. queue workers) pass the token like the middleware.
+func WithToken(ctx context.Context, t Token) context.Context {
+	return context.WithValue(ctx, contextKeyToken{}, t)
+}
+
+// ExtractToken extracts the token from the context.
+// If the token is not found, an error is returned: the reason of the token rejection (e.g. ErrExpired)
+// if the request carried an invalid token, otherwise ErrTokenNotFound.
+func ExtractToken(ctx context.Context) (Token, error) {
+	t, ok := ctx.Value(contextKeyToken{}).(Token)
+	if !ok {
+		if err, ok := ctx.Value(contextKeyTokenError{}).(error); ok {
+			return nil, err
+		}
+		return nil, ErrTokenNotFound
+	}
+	return t, nil
+}
+
+// basicCredentials is the username and password of the "Authorization: Basic" header decoded by the middleware.
+type basicCredentials struct {
+	username string
+	password string
+}
+
+// BasicCredentials returns the username and password of the "Authorization: Basic" header decoded by the middleware,
+// ok is false if the header has no correct Basic credentials (RFC 7617).
+func BasicCredentials(ctx context.Context) (username string, password string, ok bool) {
+	v, ok := ctx.Value(contextKeyBasic{}).(basicCredentials)
+	return v.username, v.password, ok
+}
+
+// BearerCredential returns the credential of the "Authorization: Bearer" header stored by the middleware.
+func BearerCredential(ctx context.Context) (string, bool) {
+	v, ok := ctx.Value(contextKeyBearer{}).(string)
+	return v, ok
+}
+
+// contextWithResponder returns a copy of the context with the responder of the rejected requests.
+func contextWithResponder(ctx context.Context, responder ErrorResponder) context.Context {
+	return context.WithValue(ctx, contextKeyErrorResponder{}, responder)
+}
+
+// withTokenError returns a copy of the context with the reason of the token rejection.
+func withTokenError(ctx context.Context, err error) context.Context {
+	return context.WithValue(ctx, contextKeyTokenError{}, err)
+}
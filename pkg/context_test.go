@@ -0,0 +1,35 @@
+package tokeninjector
+
+import (
+	"context"
+	"errors"
+	"github.com/twinj/uuid"
+	"testing"
+	"time"
+)
+
+func TestWithToken(t *testing.T) {
+	ctx := context.Background()
+	if _, err := ExtractToken(ctx); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("incorrect error, got %v", err)
+	}
+
+	expected := newToken(uuid.NewV4().String(), uuid.NewV4().String(), 1, time.Now().Add(time.Hour), nil)
+	ctx = WithToken(ctx, expected)
+	// the string keys of other packages do not collide with the keys of the middleware
+	ctx = context.WithValue(ctx, "CONTEXT_TOKEN_E2F313260669495F9D5CC67E0BD98128", uuid.NewV4().String())
+
+	v, err := ExtractToken(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.UserID() != expected.UserID() {
+		t.Errorf("incorrect token userId, got %s, expected %s", v.UserID(), expected.UserID())
+	}
+	if _, _, ok := BasicCredentials(ctx); ok {
+		t.Errorf("incorrect basic credentials, got ok")
+	}
+	if _, ok := BearerCredential(ctx); ok {
+		t.Errorf("incorrect bearer credential, got ok")
+	}
+}
@@ -0,0 +1,140 @@
+package tokeninjector
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The cookie name prefixes that browsers accept only with the matching attributes.
+const (
+	cookiePrefixSecure = "__Secure-"
+	cookiePrefixHost   = "__Host-"
+)
+
+// CookieOptions configures the cookie set by IssueCookie and cleared by ClearCookie.
+// The cookie is always HttpOnly and, unless Insecure is set, Secure.
+type CookieOptions struct {
+	// Name is the name of the cookie, the "__Host-" and "__Secure-" prefixes are checked.
+	Name string
+	// Path is the path of the cookie, by default "/".
+	Path string
+	// Domain is the domain of the cookie, by default the host of the request.
+	Domain string
+	// SameSite is the same site mode of the cookie, by default http.SameSiteLaxMode.
+	SameSite http.SameSite
+	// Insecure allows the cookie over plain HTTP, e.g. on localhost, it is rejected with the prefixes.
+	Insecure bool
+	// Partitioned sets the Partitioned attribute (CHIPS), the cookie is stored per top-level site.
+	Partitioned bool
+	// KeyRing encrypts the token, the SecretKey is used if it is not set.
+	KeyRing *KeyRing
+	// SecretKey encrypts the token like Marshal.
+	SecretKey []byte
+	// Sessions stores the token, the cookie carries only the session id (see SessionHandler), the keys are not used.
+	Sessions SessionStore
+}
+
+// IssueCookie sets the cookie with the token encrypted like MarshalWithKeyRing,
+// or with the id of the new session if the Sessions store is set. The cookie expires together with the token.
+func IssueCookie(w http.ResponseWriter, claims Token, opts CookieOptions) error {
+	if claims == nil {
+		return errors.New("token is not defined")
+	}
+
+	maxAge := int(time.Until(claims.ExpiredAt()).Seconds())
+	if maxAge <= 0 {
+		return newTokenError(ErrExpired, "expired at %s", claims.ExpiredAt())
+	}
+
+	// the cookie is checked first, so no session is created for the rejected cookie
+	c, err := newCookie(opts, "")
+	if err != nil {
+		return err
+	}
+
+	var value string
+	switch {
+	case opts.Sessions != nil:
+		value, err = CreateSession(opts.Sessions, claims)
+	case opts.KeyRing != nil:
+		value, err = marshalToken(tokenOf(claims), opts.KeyRing)
+	default:
+		var ring *KeyRing
+		if ring, err = newSingleKeyRing(opts.SecretKey); err == nil {
+			value, err = marshalToken(tokenOf(claims), ring)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	c.Value = value
+	c.Expires = claims.ExpiredAt().UTC()
+	c.MaxAge = maxAge
+
+	return setCookie(w, c, opts.Partitioned)
+}
+
+// ClearCookie removes the cookie set by IssueCookie with the same options.
+func ClearCookie(w http.ResponseWriter, opts CookieOptions) error {
+	c, err := newCookie(opts, "")
+	if err != nil {
+		return err
+	}
+	c.Expires = time.Unix(0, 0).UTC()
+	c.MaxAge = -1
+
+	return setCookie(w, c, opts.Partitioned)
+}
+
+// newCookie creates the cookie with the secure defaults and checks the rules of the name prefixes.
+func newCookie(opts CookieOptions, value string) (*http.Cookie, error) {
+	if len(opts.Name) == 0 {
+		return nil, errors.New("cookie name is not defined")
+	}
+
+	c := &http.Cookie{
+		Name:     opts.Name,
+		Value:    value,
+		Path:     opts.Path,
+		Domain:   opts.Domain,
+		Secure:   !opts.Insecure,
+		HttpOnly: true,
+		SameSite: opts.SameSite,
+	}
+	if len(c.Path) == 0 {
+		c.Path = "/"
+	}
+	if c.SameSite == 0 || c.SameSite == http.SameSiteDefaultMode {
+		c.SameSite = http.SameSiteLaxMode
+	}
+
+	switch {
+	case c.SameSite == http.SameSiteNoneMode && !c.Secure:
+		return nil, fmt.Errorf("cookie %s with SameSite=None must be secure", c.Name)
+	case opts.Partitioned && !c.Secure:
+		return nil, fmt.Errorf("partitioned cookie %s must be secure", c.Name)
+	case strings.HasPrefix(c.Name, cookiePrefixSecure) && !c.Secure:
+		return nil, fmt.Errorf("cookie %s must be secure", c.Name)
+	case strings.HasPrefix(c.Name, cookiePrefixHost) && (!c.Secure || c.Path != "/" || len(c.Domain) > 0):
+		return nil, fmt.Errorf("cookie %s must be secure, have the path / and no domain", c.Name)
+	}
+
+	return c, nil
+}
+
+// setCookie adds the Set-Cookie header, the Partitioned attribute is appended to the serialized cookie.
+func setCookie(w http.ResponseWriter, c *http.Cookie, partitioned bool) error {
+	v := c.String()
+	if len(v) == 0 {
+		return fmt.Errorf("cookie %s is invalid", c.Name)
+	}
+	if partitioned {
+		v += "; Partitioned"
+	}
+	w.Header().Add("Set-Cookie", v)
+	return nil
+}
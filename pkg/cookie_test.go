@@ -0,0 +1,98 @@
+package tokeninjector
+
+import (
+	"github.com/twinj/uuid"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIssueCookie(t *testing.T) {
+	secretKey := uuid.NewV4().Bytes()
+	ring, err := newSingleKeyRing(secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID := uuid.NewV4().String()
+	expiredAt := time.Now().Add(time.Hour)
+
+	res := httptest.NewRecorder()
+	err = IssueCookie(res, NewToken(userID, uuid.NewV4().String(), rand.Uint64(), expiredAt, WithScopes("orders:read")), CookieOptions{Name: "__Host-session", SecretKey: secretKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := res.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("incorrect number of cookies, got %d", len(cookies))
+	}
+	c := cookies[0]
+	if c.Name != "__Host-session" || c.Path != "/" || !c.Secure || !c.HttpOnly || c.SameSite != http.SameSiteLaxMode {
+		t.Errorf("incorrect cookie attributes, got %s", res.Header().Get("Set-Cookie"))
+	}
+	if c.MaxAge < 3590 || c.MaxAge > 3600 {
+		t.Errorf("incorrect cookie max age, got %d", c.MaxAge)
+	}
+	if strings.Contains(res.Header().Get("Set-Cookie"), "Partitioned") {
+		t.Errorf("incorrect cookie, got %s", res.Header().Get("Set-Cookie"))
+	}
+
+	tkn, err := UnmarshalToken(c.Value, ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tkn.UserID() != userID || !HasScope(tkn, "orders:read") {
+		t.Errorf("incorrect token, got %s %v", tkn.UserID(), tkn.Scopes())
+	}
+}
+
+func TestIssueCookie_Rejected(t *testing.T) {
+	secretKey := uuid.NewV4().Bytes()
+	tkn := NewToken(uuid.NewV4().String(), uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Hour))
+
+	tests := []struct {
+		name string
+		opts CookieOptions
+	}{
+		{"no name", CookieOptions{SecretKey: secretKey}},
+		{"no key", CookieOptions{Name: "session"}},
+		{"insecure __Secure-", CookieOptions{Name: "__Secure-session", Insecure: true, SecretKey: secretKey}},
+		{"__Host- with domain", CookieOptions{Name: "__Host-session", Domain: "example.com", SecretKey: secretKey}},
+		{"__Host- with path", CookieOptions{Name: "__Host-session", Path: "/api", SecretKey: secretKey}},
+		{"insecure SameSite=None", CookieOptions{Name: "session", SameSite: http.SameSiteNoneMode, Insecure: true, SecretKey: secretKey}},
+		{"insecure partitioned", CookieOptions{Name: "session", Partitioned: true, Insecure: true, SecretKey: secretKey}},
+	}
+	for _, tt := range tests {
+		res := httptest.NewRecorder()
+		if err := IssueCookie(res, tkn, tt.opts); err == nil {
+			t.Errorf("%s: incorrect cookie is accepted", tt.name)
+		}
+		if len(res.Header().Values("Set-Cookie")) > 0 {
+			t.Errorf("%s: incorrect cookie is set", tt.name)
+		}
+	}
+
+	res := httptest.NewRecorder()
+	expired := NewToken(uuid.NewV4().String(), uuid.NewV4().String(), rand.Uint64(), time.Now().Add(-time.Second))
+	if err := IssueCookie(res, expired, CookieOptions{Name: "session", SecretKey: secretKey}); err == nil {
+		t.Errorf("incorrect cookie of the expired token is accepted")
+	}
+}
+
+func TestClearCookie(t *testing.T) {
+	res := httptest.NewRecorder()
+	opts := CookieOptions{Name: "__Secure-session", Path: "/app", Domain: "example.com", SameSite: http.SameSiteNoneMode, Partitioned: true}
+	if err := ClearCookie(res, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	header := res.Header().Get("Set-Cookie")
+	for _, attr := range []string{"__Secure-session=;", "Path=/app", "Domain=example.com", "Max-Age=0", "HttpOnly", "Secure", "SameSite=None", "Partitioned"} {
+		if !strings.Contains(header, attr) {
+			t.Errorf("incorrect cookie, %s is not found in %s", attr, header)
+		}
+	}
+}
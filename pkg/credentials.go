@@ -0,0 +1,99 @@
+package tokeninjector
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CredentialVerifier verifies the username and password of the "Authorization: Basic" header
+// and returns the token of the authenticated user, see WithBasicVerifier.
+// The rejected credentials are reported with ErrInvalidCredentials.
+type CredentialVerifier interface {
+	Verify(username string, password string) (Token, error)
+}
+
+// CredentialTokenLifetime is the lifetime of the token returned by the built-in verifiers,
+// the credentials are verified on each request, so the token lives only for the request.
+var CredentialTokenLifetime = time.Minute
+
+// newCredentialToken creates the token of the user authenticated by the credentials.
+func newCredentialToken(username string, roleID uint64) *token {
+	return newToken(username, username, roleID, time.Now().Add(CredentialTokenLifetime), nil)
+}
+
+// decodeBasicCredentials decodes the credential of the Basic method into the username and password (RFC 7617),
+// the username is the part before the first colon.
+func decodeBasicCredentials(credential string) (username string, password string, err error) {
+	b, err := base64.StdEncoding.DecodeString(credential)
+	if err != nil {
+		return "", "", asTokenError(ErrMalformed, err)
+	}
+	username, password, ok := strings.Cut(string(b), ":")
+	if !ok {
+		return "", "", newTokenError(ErrMalformed, "basic credentials have no colon")
+	}
+	return username, password, nil
+}
+
+// MemoryVerifier is the CredentialVerifier of the users stored in memory.
+// The passwords are compared in constant time, unknown users take the same time as the wrong passwords.
+type MemoryVerifier struct {
+	mutex sync.RWMutex
+	users map[string]memoryUser
+}
+
+// memoryUser is the user of MemoryVerifier, the password is stored as its SHA-256 hash.
+type memoryUser struct {
+	password [sha256.Size]byte
+	roleID   uint64
+}
+
+// NewMemoryVerifier creates an empty MemoryVerifier.
+func NewMemoryVerifier() *MemoryVerifier {
+	return &MemoryVerifier{users: make(map[string]memoryUser)}
+}
+
+// Add adds the user or replaces the password and role id of the existing one.
+// The username must not be empty or contain a colon, which separates it from the password.
+func (v *MemoryVerifier) Add(username string, password string, roleID uint64) error {
+	if len(username) == 0 {
+		return fmt.Errorf("username is empty")
+	}
+	if strings.Contains(username, ":") {
+		return fmt.Errorf("username %q contains a colon", username)
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.users[username] = memoryUser{password: sha256.Sum256([]byte(password)), roleID: roleID}
+
+	return nil
+}
+
+// Remove removes the user.
+func (v *MemoryVerifier) Remove(username string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	delete(v.users, username)
+}
+
+// Verify returns the token of the user if the password is correct.
+func (v *MemoryVerifier) Verify(username string, password string) (Token, error) {
+	v.mutex.RLock()
+	u, ok := v.users[username]
+	v.mutex.RUnlock()
+
+	hash := sha256.Sum256([]byte(password))
+	if subtle.ConstantTimeCompare(hash[:], u.password[:]) != 1 || !ok {
+		return nil, newTokenError(ErrInvalidCredentials, "user %q", username)
+	}
+
+	return newCredentialToken(username, u.roleID), nil
+}
@@ -0,0 +1,184 @@
+package tokeninjector
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/prorochestvo/tokeninjector/internal"
+	"github.com/twinj/uuid"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDecodeBasicCredentials(t *testing.T) {
+	tests := []struct {
+		credential string
+		username   string
+		password   string
+		err        error
+	}{
+		{base64.StdEncoding.EncodeToString([]byte("Aladdin:open sesame")), "Aladdin", "open sesame", nil},
+		{base64.StdEncoding.EncodeToString([]byte("user:pass:word")), "user", "pass:word", nil},
+		{base64.StdEncoding.EncodeToString([]byte("user:")), "user", "", nil},
+		{base64.StdEncoding.EncodeToString([]byte("test:123£")), "test", "123£", nil},
+		{base64.StdEncoding.EncodeToString([]byte("user")), "", "", ErrMalformed},
+		{"*", "", "", ErrMalformed},
+	}
+	for _, tt := range tests {
+		username, password, err := decodeBasicCredentials(tt.credential)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("%s: incorrect error, got %v", tt.credential, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.credential, err.Error())
+		}
+		if username != tt.username || password != tt.password {
+			t.Errorf("%s: incorrect credentials, got %s %s", tt.credential, username, password)
+		}
+	}
+}
+
+func TestMemoryVerifier(t *testing.T) {
+	v := NewMemoryVerifier()
+	username := uuid.NewV4().String()
+	password := uuid.NewV4().String()
+	if err := v.Add(username, password, 7); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Add("user:name", password, 7); err == nil {
+		t.Errorf("incorrect add, the username with a colon is accepted")
+	}
+
+	tkn, err := v.Verify(username, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tkn.UserID() != username || tkn.UserRoleID() != 7 {
+		t.Errorf("incorrect token, got %s %d", tkn.UserID(), tkn.UserRoleID())
+	}
+	if _, err = v.Verify(username, password+"x"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("incorrect error of the wrong password, got %v", err)
+	}
+	if _, err = v.Verify(uuid.NewV4().String(), password); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("incorrect error of the unknown user, got %v", err)
+	}
+	// the zero hash of an unknown user must not match any password
+	if _, err = v.Verify("", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("incorrect error of the empty user, got %v", err)
+	}
+
+	v.Remove(username)
+	if _, err = v.Verify(username, password); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("incorrect error of the removed user, got %v", err)
+	}
+}
+
+func TestTokenHandler_BasicVerifier(t *testing.T) {
+	v := NewMemoryVerifier()
+	username := uuid.NewV4().String()
+	password := uuid.NewV4().String()
+	if err := v.Add(username, password, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	var userID, basicUsername string
+	var tokenErr error
+	h, err := TokenHandler(uuid.NewV4().Bytes(), uuid.NewV4().String(), func(w http.ResponseWriter, r *http.Request) {
+		basicUsername, _, _ = BasicCredentials(r.Context())
+		v, err := ExtractToken(r.Context())
+		if tokenErr = err; err == nil {
+			userID = v.UserID()
+		}
+	}, WithBasicVerifier(v))
+	if err != nil {
+		t.Fatalf("could not create nextHandler; details: %s", err.Error())
+	}
+
+	for pass, expected := range map[string]error{password: nil, uuid.NewV4().String(): ErrInvalidCredentials} {
+		userID, basicUsername, tokenErr = "", "", nil
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		credential := base64.StdEncoding.EncodeToString([]byte(username + ":" + pass))
+		req.Header.Set(internal.HeaderAuthorization, fmt.Sprintf("%s %s", internal.AuthMethodBasic, credential))
+
+		h(res, req)
+		if basicUsername != username {
+			t.Errorf("incorrect basic username, got %s", basicUsername)
+		}
+		if expected == nil && (tokenErr != nil || userID != username) {
+			t.Errorf("incorrect token, got %s, %v", userID, tokenErr)
+		}
+		if expected != nil && !errors.Is(tokenErr, expected) {
+			t.Errorf("incorrect error, got %v", tokenErr)
+		}
+	}
+}
+
+func TestTokenHandler_BasicVerifier_Revoked(t *testing.T) {
+	v := NewMemoryVerifier()
+	username := uuid.NewV4().String()
+	password := uuid.NewV4().String()
+	if err := v.Add(username, password, 3); err != nil {
+		t.Fatal(err)
+	}
+	gens := NewMemoryGenerationStore()
+
+	var tokenErr error
+	h, err := TokenHandler(uuid.NewV4().Bytes(), uuid.NewV4().String(), func(w http.ResponseWriter, r *http.Request) {
+		_, tokenErr = ExtractToken(r.Context())
+	}, WithBasicVerifier(v), WithValidation(WithGenerationCheck(gens)))
+	if err != nil {
+		t.Fatalf("could not create nextHandler; details: %s", err.Error())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth(username, password)
+	h(httptest.NewRecorder(), req)
+	if tokenErr != nil {
+		t.Errorf("incorrect error of the valid user, got %v", tokenErr)
+	}
+
+	if _, err = gens.Increment(username); err != nil {
+		t.Fatal(err)
+	}
+	h(httptest.NewRecorder(), req)
+	if !errors.Is(tokenErr, ErrRevoked) {
+		t.Errorf("incorrect error of the revoked user, got %v, expected %v", tokenErr, ErrRevoked)
+	}
+}
+
+func TestBasicAuthenticator(t *testing.T) {
+	v := NewMemoryVerifier()
+	if err := v.Add("service", "secret", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	var authenticatedBy string
+	h, err := AuthenticatorHandler([]Authenticator{BasicAuthenticator(v)}, func(w http.ResponseWriter, r *http.Request) {
+		authenticatedBy, _ = AuthenticatedBy(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}, WithRequireToken())
+	if err != nil {
+		t.Fatalf("could not create nextHandler; details: %s", err.Error())
+	}
+
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth("service", "secret")
+	h(res, req)
+	if res.Code != http.StatusOK || authenticatedBy != "basic" {
+		t.Errorf("incorrect response, got %d by %s", res.Code, authenticatedBy)
+	}
+
+	res = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth("service", "wrong")
+	h(res, req)
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("incorrect response code, got %d", res.Code)
+	}
+}
@@ -0,0 +1,83 @@
+package tokeninjector
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The reasons of the token rejection, they are matched with errors.Is.
+var (
+	// ErrTokenNotFound is returned by ExtractToken if the request has no token.
+	ErrTokenNotFound = errors.New("token not found")
+	// ErrMalformed means that the token could not be decoded.
+	ErrMalformed = errors.New("token is malformed")
+	// ErrTampered means that the token failed the authenticity check, i.e. it is forged or was modified.
+	ErrTampered = errors.New("token is tampered")
+	// ErrExpired means that the token is expired or older than the allowed maximum age.
+	ErrExpired = errors.New("token is expired")
+	// ErrNotYetValid means that the not-before time of the token is in the future.
+	ErrNotYetValid = errors.New("token is not valid yet")
+	// ErrUnknownKey means that the key of the token is not in the key ring or is retired.
+	ErrUnknownKey = errors.New("token key is unknown")
+	// ErrUnsupportedVersion means that the token format is not supported, e.g. v1 after the migration window.
+	ErrUnsupportedVersion = errors.New("token version is unsupported")
+	// ErrRevoked means that the token was revoked before its expiration, e.g. on logout.
+	ErrRevoked = errors.New("token is revoked")
+	// ErrUnavailable means that the token could not be checked, e.g. the revocation store failed.
+	ErrUnavailable = errors.New("token check is unavailable")
+	// ErrUnknownSession means that the session of the reference token is unknown, expired or deleted.
+	ErrUnknownSession = errors.New("token session is unknown")
+	// ErrRefreshInvalid means that the refresh token is unknown or its family was revoked.
+	ErrRefreshInvalid = errors.New("refresh token is invalid")
+	// ErrRefreshReused means that the refresh token was already exchanged, its family is revoked.
+	ErrRefreshReused = errors.New("refresh token is reused")
+	// ErrTokenConflict means that the cookie and the bearer credential carry tokens of different users.
+	ErrTokenConflict = errors.New("tokens of the cookie and the bearer credential conflict")
+	// ErrInvalidCredentials means that the username or password of the Basic method is wrong.
+	ErrInvalidCredentials = errors.New("credentials are invalid")
+	// ErrRoleNotAllowed means that the role of the token is not allowed to access the resource.
+	ErrRoleNotAllowed = errors.New("token role is not allowed")
+	// ErrPermissionDenied means that the permissions of the token do not include the required ones.
+	ErrPermissionDenied = errors.New("token permission is denied")
+	// ErrInsufficientScope means that the scopes of the token do not include the required ones.
+	ErrInsufficientScope = errors.New("token scope is insufficient")
+	// ErrInvalidClaims means that the claims of the token do not match the expected ones, e.g. the audience.
+	ErrInvalidClaims = errors.New("token claims are invalid")
+)
+
+// TokenError is the error of the token rejection, the reason is one of the sentinel errors (e.g. ErrExpired).
+// errors.Is matches both the reason and the cause.
+type TokenError struct {
+	Reason error
+	Err    error
+}
+
+// newTokenError creates the error of the token rejection with the reason and formatted cause.
+func newTokenError(reason error, format string, args ...any) *TokenError {
+	return &TokenError{Reason: reason, Err: fmt.Errorf(format, args...)}
+}
+
+// asTokenError returns the error if it is already a TokenError, otherwise wraps it with the reason.
+func asTokenError(reason error, err error) *TokenError {
+	var e *TokenError
+	if errors.As(err, &e) {
+		return e
+	}
+	return &TokenError{Reason: reason, Err: err}
+}
+
+// Error returns the reason and the cause of the token rejection.
+func (e *TokenError) Error() string {
+	if e.Err == nil {
+		return e.Reason.Error()
+	}
+	return e.Reason.Error() + ": " + e.Err.Error()
+}
+
+// Unwrap returns the reason and the cause of the token rejection.
+func (e *TokenError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Reason}
+	}
+	return []error{e.Reason, e.Err}
+}
@@ -0,0 +1,74 @@
+package tokeninjector
+
+import (
+	"encoding/base64"
+	"errors"
+	"github.com/twinj/uuid"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestUnmarshalToken_Errors(t *testing.T) {
+	secretKey := uuid.NewV4().Bytes()
+	ring := NewKeyRing()
+	if err := ring.AddKey("k1", secretKey, KeyActive); err != nil {
+		t.Fatal(err)
+	}
+	marshal := func(expiredAt time.Time, opts ...MarshalOption) string {
+		dataset, err := MarshalWithKeyRing(uuid.NewV4().String(), uuid.NewV4().String(), rand.Uint64(), expiredAt, ring, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dataset
+	}
+	expiredAt := time.Now().Add(time.Hour)
+
+	tampered, err := base64.StdEncoding.DecodeString(marshal(expiredAt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered[len(tampered)-1] ^= 0x01
+
+	legacy, err := encryptLegacy(convertToByteLegacy([]byte("id"), nil, 0, uint64(expiredAt.Unix())), secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := NewKeyRing()
+	if err = other.AddKey("k2", uuid.NewV4().Bytes(), KeyActive); err != nil {
+		t.Fatal(err)
+	}
+	unknownKey, err := MarshalWithKeyRing("id", "name", 0, expiredAt, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ring.SetAcceptLegacyTokens(false)
+
+	testCases := map[string]struct {
+		data     string
+		opts     []ValidateOption
+		expected error
+	}{
+		"malformed":           {data: "!" + marshal(expiredAt), expected: ErrMalformed},
+		"tampered":            {data: base64.StdEncoding.EncodeToString(tampered), expected: ErrTampered},
+		"expired":             {data: marshal(time.Now().Add(-time.Minute)), expected: ErrExpired},
+		"older than max age":  {data: marshal(expiredAt, WithIssuedAt(time.Now().Add(-time.Hour))), opts: []ValidateOption{WithMaxAge(time.Minute)}, expected: ErrExpired},
+		"not yet valid":       {data: marshal(expiredAt, WithNotBefore(time.Now().Add(time.Hour))), expected: ErrNotYetValid},
+		"unknown key":         {data: unknownKey, expected: ErrUnknownKey},
+		"unsupported version": {data: base64.StdEncoding.EncodeToString(legacy), expected: ErrUnsupportedVersion},
+		"another audience":    {data: marshal(expiredAt, WithAudience("a")), opts: []ValidateOption{WithExpectedAudience("b")}, expected: ErrInvalidClaims},
+	}
+	for name, tc := range testCases {
+		_, err := UnmarshalToken(tc.data, ring, tc.opts...)
+		if !errors.Is(err, tc.expected) {
+			t.Errorf("%s: incorrect error, got %v, expected %v", name, err, tc.expected)
+			continue
+		}
+		var tokenErr *TokenError
+		if !errors.As(err, &tokenErr) || tokenErr.Reason != tc.expected {
+			t.Errorf("%s: error is not a TokenError with the reason, got %#v", name, err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package tokeninjector
+
+import (
+	"sync"
+	"time"
+)
+
+// GenerationStore keeps the current generation of the sessions of each user and the global cutoff,
+// see WithGenerationCheck. Incrementing the generation of the user invalidates all tokens of the user,
+// moving the cutoff invalidates all tokens issued before it, each is a single write.
+type GenerationStore interface {
+	// Generation returns the current generation of the user, the tokens of older generations are rejected.
+	Generation(userID string) (uint64, error)
+	// Cutoff returns the time before which the tokens are rejected, the zero time disables the cutoff.
+	Cutoff() (time.Time, error)
+}
+
+// MemoryGenerationStore is the GenerationStore in memory, the users start with the generation 0.
+type MemoryGenerationStore struct {
+	mutex       sync.RWMutex
+	generations map[string]uint64
+	cutoff      time.Time
+}
+
+// NewMemoryGenerationStore creates an empty MemoryGenerationStore.
+func NewMemoryGenerationStore() *MemoryGenerationStore {
+	return &MemoryGenerationStore{generations: make(map[string]uint64)}
+}
+
+// Generation returns the current generation of the user.
+func (s *MemoryGenerationStore) Generation(userID string) (uint64, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.generations[userID], nil
+}
+
+// Increment invalidates the tokens of the user and returns the new generation for the new tokens.
+func (s *MemoryGenerationStore) Increment(userID string) (uint64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.generations[userID]++
+
+	return s.generations[userID], nil
+}
+
+// Cutoff returns the time before which the tokens are rejected.
+func (s *MemoryGenerationStore) Cutoff() (time.Time, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.cutoff, nil
+}
+
+// SetCutoff rejects the tokens issued before the time, the zero time disables the cutoff.
+func (s *MemoryGenerationStore) SetCutoff(cutoff time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.cutoff = cutoff
+}
+
+// validateGeneration checks the generation and the issue time of the token against the store.
+// The tokens without the issue time are rejected if the cutoff is set.
+func validateGeneration(t *token, store GenerationStore) error {
+	cutoff, err := store.Cutoff()
+	if err != nil {
+		return newTokenError(ErrUnavailable, "could not check the cutoff of the token; details: %s", err.Error())
+	}
+	if !cutoff.IsZero() && (t.issuedAt.IsZero() || t.issuedAt.Before(cutoff.Truncate(time.Second))) {
+		return newTokenError(ErrRevoked, "issued at %s before the cutoff %s", t.issuedAt, cutoff)
+	}
+
+	generation, err := store.Generation(t.userID)
+	if err != nil {
+		return newTokenError(ErrUnavailable, "could not check the generation of the token; details: %s", err.Error())
+	}
+	if t.generation < generation {
+		return newTokenError(ErrRevoked, "generation %d is older than %d", t.generation, generation)
+	}
+
+	return nil
+}
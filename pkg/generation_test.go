@@ -0,0 +1,102 @@
+package tokeninjector
+
+import (
+	"errors"
+	"github.com/twinj/uuid"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGenerationCheck(t *testing.T) {
+	secretKey := uuid.NewV4().Bytes()
+	ring, err := newSingleKeyRing(secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryGenerationStore()
+	userID := uuid.NewV4().String()
+	otherUserID := uuid.NewV4().String()
+
+	marshal := func(userID string, generation uint64, issuedAt time.Time) string {
+		s, err := Marshal(userID, uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Hour), secretKey, WithGeneration(generation), WithIssuedAt(issuedAt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	first := marshal(userID, 0, time.Now())
+	other := marshal(otherUserID, 0, time.Now())
+	if _, err = UnmarshalToken(first, ring, WithGenerationCheck(store)); err != nil {
+		t.Errorf("incorrect error of the current generation, got %v", err)
+	}
+
+	// log out everywhere
+	generation, err := store.Increment(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := marshal(userID, generation, time.Now())
+	if _, err = UnmarshalToken(first, ring, WithGenerationCheck(store)); !errors.Is(err, ErrRevoked) {
+		t.Errorf("incorrect error of the old generation, got %v", err)
+	}
+	tkn, err := UnmarshalToken(second, ring, WithGenerationCheck(store))
+	if err != nil {
+		t.Fatalf("incorrect error of the new generation, got %v", err)
+	}
+	if tkn.Generation() != generation {
+		t.Errorf("incorrect generation, got %d, expected %d", tkn.Generation(), generation)
+	}
+	if _, err = UnmarshalToken(other, ring, WithGenerationCheck(store)); err != nil {
+		t.Errorf("incorrect error of another user, got %v", err)
+	}
+
+	// invalidate everything
+	store.SetCutoff(time.Now().Add(time.Second))
+	for _, s := range []string{second, other, marshal(otherUserID, 0, time.Time{})} {
+		if _, err = UnmarshalToken(s, ring, WithGenerationCheck(store)); !errors.Is(err, ErrRevoked) {
+			t.Errorf("incorrect error of the token before the cutoff, got %v", err)
+		}
+	}
+	if _, err = UnmarshalToken(marshal(otherUserID, 0, time.Now().Add(2*time.Second)), ring, WithGenerationCheck(store)); err != nil {
+		t.Errorf("incorrect error of the token after the cutoff, got %v", err)
+	}
+}
+
+func TestTokenHandler_GenerationStore(t *testing.T) {
+	secretKey := uuid.NewV4().Bytes()
+	cookieName := uuid.NewV4().String()
+	store := NewMemoryGenerationStore()
+	userID := uuid.NewV4().String()
+
+	cookieValue, err := Marshal(userID, uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Hour), secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := TokenHandler(secretKey, cookieName, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}, WithGenerationStore(store), WithRequireToken())
+	if err != nil {
+		t.Fatalf("could not create nextHandler; details: %s", err.Error())
+	}
+
+	for i, code := range []int{http.StatusOK, http.StatusUnauthorized} {
+		if i == 1 {
+			if _, err = store.Increment(userID); err != nil {
+				t.Fatal(err)
+			}
+		}
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: cookieName, Value: cookieValue, HttpOnly: true})
+
+		h(res, req)
+		if res.Code != code {
+			t.Errorf("%d: incorrect response code, got %d", i, res.Code)
+		}
+	}
+}
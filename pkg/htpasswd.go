@@ -0,0 +1,126 @@
+package tokeninjector
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/prorochestvo/tokeninjector/internal/crypto/crypt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileCheckInterval is the minimal interval between the checks of the modification of the watched files,
+// i.e. the htpasswd file and the revocation file.
+const fileCheckInterval = time.Second
+
+// htpasswdDummyHash is verified for the unknown users if the file has no supported hashes.
+const htpasswdDummyHash = "$apr1$dummy$oOGtBvkOovtUFuiCvx4yy."
+
+// HtpasswdVerifier is the CredentialVerifier of the users of the Apache htpasswd file,
+// the verified users get the token with the role id of the verifier.
+// The supported hashes are {SHA}, $apr1$ (MD5), $5$ (SHA-256) and $6$ (SHA-512), the users with other hashes
+// (e.g. bcrypt) are rejected. The file is reloaded when its modification time or size changes,
+// the users of the last correct file are kept if the new one could not be loaded.
+type HtpasswdVerifier struct {
+	path    string
+	roleID  uint64
+	mutex   sync.Mutex
+	users   map[string]string
+	dummy   string
+	modTime time.Time
+	size    int64
+	checked time.Time
+}
+
+// NewHtpasswdVerifier creates the verifier of the htpasswd file, the file must exist and be correct.
+func NewHtpasswdVerifier(path string, roleID uint64) (*HtpasswdVerifier, error) {
+	v := &HtpasswdVerifier{path: path, roleID: roleID}
+	if err := v.reload(time.Now()); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Verify returns the token of the user if the password matches the hash of the htpasswd file.
+func (v *HtpasswdVerifier) Verify(username string, password string) (Token, error) {
+	v.mutex.Lock()
+	if now := time.Now(); now.Sub(v.checked) >= fileCheckInterval {
+		_ = v.reload(now)
+	}
+	hash, ok := v.users[username]
+	dummy := v.dummy
+	v.mutex.Unlock()
+
+	if !ok {
+		// the hash of the file has the scheme and cost of the real users, so the unknown users take the same time
+		_, _ = crypt.Verify([]byte(password), dummy)
+		return nil, newTokenError(ErrInvalidCredentials, "user %q", username)
+	}
+	matched, err := crypt.Verify([]byte(password), hash)
+	if err != nil {
+		return nil, newTokenError(ErrInvalidCredentials, "user %q; details: %s", username, err.Error())
+	}
+	if !matched {
+		return nil, newTokenError(ErrInvalidCredentials, "user %q", username)
+	}
+
+	return newCredentialToken(username, v.roleID), nil
+}
+
+// reload loads the file if it was modified since the last load, the caller holds the lock.
+func (v *HtpasswdVerifier) reload(now time.Time) error {
+	v.checked = now
+
+	info, err := os.Stat(v.path)
+	if err != nil {
+		return err
+	}
+	if v.users != nil && info.ModTime().Equal(v.modTime) && info.Size() == v.size {
+		return nil
+	}
+
+	data, err := os.ReadFile(v.path)
+	if err != nil {
+		return err
+	}
+	users, dummy, err := parseHtpasswd(data)
+	if err != nil {
+		return fmt.Errorf("could not parse %s; details: %s", v.path, err.Error())
+	}
+
+	v.users = users
+	v.dummy = dummy
+	v.modTime = info.ModTime()
+	v.size = info.Size()
+
+	return nil
+}
+
+// parseHtpasswd parses the lines "username:hash" of the htpasswd file, empty lines and comments are skipped.
+// The dummy is the first supported hash of the file, it is verified for the unknown users.
+func parseHtpasswd(data []byte) (users map[string]string, dummy string, err error) {
+	users = make(map[string]string)
+	dummy = htpasswdDummyHash
+	found := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		username, hash, ok := strings.Cut(line, ":")
+		if !ok || len(username) == 0 || len(hash) == 0 {
+			return nil, "", fmt.Errorf("incorrect line %d", n)
+		}
+		users[username] = hash
+		if !found && crypt.Supported(hash) {
+			dummy, found = hash, true
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, "", err
+	}
+	return users, dummy, nil
+}
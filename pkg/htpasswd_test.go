@@ -0,0 +1,144 @@
+package tokeninjector
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/prorochestvo/tokeninjector/internal"
+	"github.com/twinj/uuid"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// the hashes of "Hello world!" are created by "openssl passwd"
+const testHtpasswd = `# service credentials
+sha1:{SHA}00hq6RNueFa8QiEjhep5cJRHWAI=
+apr1:$apr1$saltstr$657VxpqLCAXXzVs.p3YvD/
+sha256:$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5
+
+sha512:$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1
+bcrypt:$2y$05$c4WoMPo3SXsafkva.HHa6uXQZWr7oboPiC2bT/r7q1BB8I2s0BRqC
+`
+
+func TestHtpasswdVerifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".htpasswd")
+	if err := os.WriteFile(path, []byte(testHtpasswd), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := NewHtpasswdVerifier(path, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, username := range []string{"sha1", "apr1", "sha256", "sha512"} {
+		tkn, err := v.Verify(username, "Hello world!")
+		if err != nil {
+			t.Errorf("%s: %s", username, err.Error())
+			continue
+		}
+		if tkn.UserID() != username || tkn.UserRoleID() != 5 {
+			t.Errorf("%s: incorrect token, got %s %d", username, tkn.UserID(), tkn.UserRoleID())
+		}
+		if _, err = v.Verify(username, "Hello world"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("%s: incorrect error of the wrong password, got %v", username, err)
+		}
+	}
+	for _, username := range []string{"bcrypt", "unknown", "#"} {
+		if _, err = v.Verify(username, "Hello world!"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("%s: incorrect error, got %v", username, err)
+		}
+	}
+
+	// the file is reloaded after the modification
+	if err = os.WriteFile(path, []byte("sha1:{SHA}00hq6RNueFa8QiEjhep5cJRHWAI=\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	v.checked = time.Time{}
+	if _, err = v.Verify("apr1", "Hello world!"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("incorrect error of the removed user, got %v", err)
+	}
+	if _, err = v.Verify("sha1", "Hello world!"); err != nil {
+		t.Errorf("incorrect error of the kept user, got %v", err)
+	}
+
+	// the users are kept if the file is broken
+	if err = os.WriteFile(path, []byte("broken\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	v.checked = time.Time{}
+	if _, err = v.Verify("sha1", "Hello world!"); err != nil {
+		t.Errorf("incorrect error of the broken file, got %v", err)
+	}
+
+	if _, err = NewHtpasswdVerifier(path, 5); err == nil {
+		t.Errorf("incorrect verifier, the broken file is accepted")
+	}
+	if _, err = NewHtpasswdVerifier(filepath.Join(t.TempDir(), "missing"), 5); err == nil {
+		t.Errorf("incorrect verifier, the missing file is accepted")
+	}
+}
+
+func TestParseHtpasswd_Dummy(t *testing.T) {
+	sha512 := "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"
+	tests := []struct {
+		data  string
+		dummy string
+	}{
+		{"bcrypt:$2y$05$c4WoMPo3SXsafkva.HHa6uXQZWr7oboPiC2bT/r7q1BB8I2s0BRqC\nsha512:" + sha512 + "\n", sha512},
+		{testHtpasswd, "{SHA}00hq6RNueFa8QiEjhep5cJRHWAI="},
+		{"# no users\n", htpasswdDummyHash},
+	}
+	for _, tt := range tests {
+		_, dummy, err := parseHtpasswd([]byte(tt.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dummy != tt.dummy {
+			t.Errorf("incorrect dummy hash, got %s, expected %s", dummy, tt.dummy)
+		}
+	}
+}
+
+func TestTokenHandler_HtpasswdVerifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".htpasswd")
+	if err := os.WriteFile(path, []byte(testHtpasswd), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewHtpasswdVerifier(path, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := TokenHandler(uuid.NewV4().Bytes(), "", func(w http.ResponseWriter, r *http.Request) {
+		tkn, err := ExtractToken(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(fmt.Sprintf("%s:%d", tkn.UserID(), tkn.UserRoleID())))
+	}, WithBasicVerifier(v), WithRequireToken())
+	if err != nil {
+		t.Fatalf("could not create nextHandler; details: %s", err.Error())
+	}
+
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	credential := base64.StdEncoding.EncodeToString([]byte("sha512:Hello world!"))
+	req.Header.Set(internal.HeaderAuthorization, fmt.Sprintf("%s %s", internal.AuthMethodBasic, credential))
+
+	h(res, req)
+	if res.Code != http.StatusOK {
+		t.Errorf("incorrect response code, got %d", res.Code)
+	}
+	if s := res.Body.String(); s != "sha512:5" {
+		t.Errorf("incorrect response body, got %s", s)
+	}
+}
@@ -0,0 +1,282 @@
+package tokeninjector
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The algorithms of the JWT signature supported by MarshalJWT and UnmarshalJWT.
+// The algorithm "none" is never accepted.
+const (
+	JWTAlgorithmHS256 = "HS256"
+	JWTAlgorithmHS384 = "HS384"
+	JWTAlgorithmHS512 = "HS512"
+	JWTAlgorithmEdDSA = "EdDSA"
+)
+
+// MarshalJWT creates a JWT (JWS compact serialization) from the user id ("sub"), user name ("name"),
+// role id ("role"), and expiration time ("exp"), the registered claims are set by the options.
+//   - alg: the algorithm of the signature, one of JWTAlgorithmHS256, JWTAlgorithmHS384, JWTAlgorithmHS512, JWTAlgorithmEdDSA.
+//   - key: the secret key ([]byte) for HS algorithms or the private key (ed25519.PrivateKey) for EdDSA.
+//
+// The additional claims set by the options are not supported by JWT.
+func MarshalJWT(userID string, userName string, roleID uint64, expiredAt time.Time, alg string, key any, opts ...MarshalOption) (string, error) {
+	signer, err := newJWTSigner(alg, key, true)
+	if err != nil {
+		return "", err
+	}
+	return marshalJWTToken(newToken(userID, userName, roleID, expiredAt, opts), signer)
+}
+
+// UnmarshalJWT extracts the token from the JWT and verifies its signature.
+// The algorithm in the JWT header must be exactly the expected one, so the algorithm confusion is not possible.
+// Like UnmarshalToken, it rejects expired tokens and performs the checks set by the options.
+//   - alg: the expected algorithm of the signature.
+//   - key: the secret key ([]byte) for HS algorithms or the public key (ed25519.PublicKey) for EdDSA.
+func UnmarshalJWT(data string, alg string, key any, opts ...ValidateOption) (Token, error) {
+	verifier, err := newJWTSigner(alg, key, false)
+	if err != nil {
+		return nil, err
+	}
+	t, err := unmarshalJWTToken(data, verifier)
+	if err != nil {
+		return nil, err
+	}
+	if err = validateToken(t, newValidateOptions(opts)); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// jwtSigner signs and verifies the JWT with one algorithm and key.
+type jwtSigner struct {
+	alg    string
+	sign   func(data []byte) []byte
+	verify func(data, signature []byte) bool
+}
+
+// newJWTSigner checks that the key matches the algorithm and creates the signer.
+// The private key is required for EdDSA if sign is set, otherwise the public key.
+func newJWTSigner(alg string, key any, sign bool) (*jwtSigner, error) {
+	switch alg {
+	case JWTAlgorithmHS256, JWTAlgorithmHS384, JWTAlgorithmHS512:
+		secretKey, ok := key.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("unsupported key %T for %s, expected []byte", key, alg)
+		}
+		newHash := map[string]func() hash.Hash{
+			JWTAlgorithmHS256: sha256.New,
+			JWTAlgorithmHS384: sha512.New384,
+			JWTAlgorithmHS512: sha512.New,
+		}[alg]
+		if l := newHash().Size(); len(secretKey) < l {
+			return nil, fmt.Errorf("secret key for %s is too short, got %d, minimum %d", alg, len(secretKey), l)
+		}
+		secretKey = bytes.Clone(secretKey)
+		s := &jwtSigner{alg: alg}
+		s.sign = func(data []byte) []byte {
+			h := hmac.New(newHash, secretKey)
+			h.Write(data)
+			return h.Sum(nil)
+		}
+		s.verify = func(data, signature []byte) bool {
+			return hmac.Equal(s.sign(data), signature)
+		}
+		return s, nil
+	case JWTAlgorithmEdDSA:
+		s := &jwtSigner{alg: alg}
+		if sign {
+			privateKey, ok := key.(ed25519.PrivateKey)
+			if !ok || len(privateKey) != ed25519.PrivateKeySize {
+				return nil, fmt.Errorf("unsupported key %T for %s, expected ed25519.PrivateKey", key, alg)
+			}
+			s.sign = func(data []byte) []byte { return ed25519.Sign(privateKey, data) }
+		} else {
+			publicKey, err := ed25519PublicKey(key)
+			if err != nil {
+				return nil, err
+			}
+			s.verify = func(data, signature []byte) bool { return ed25519.Verify(publicKey, data, signature) }
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unsupported JWT algorithm %q", alg)
+	}
+}
+
+// jwtHeader is the JOSE header of the JWT.
+type jwtHeader struct {
+	Algorithm string   `json:"alg"`
+	Type      string   `json:"typ,omitempty"`
+	Critical  []string `json:"crit,omitempty"`
+}
+
+// jwtClaims is the claims set of the JWT.
+type jwtClaims struct {
+	Subject   string         `json:"sub"`
+	Name      string         `json:"name,omitempty"`
+	Role      uint64         `json:"role,omitempty"`
+	ExpiresAt jwtNumericDate `json:"exp"`
+	IssuedAt  jwtNumericDate `json:"iat,omitempty"`
+	NotBefore jwtNumericDate `json:"nbf,omitempty"`
+	Issuer    string         `json:"iss,omitempty"`
+	Audience  jwtAudience    `json:"aud,omitempty"`
+	ID        string         `json:"jti,omitempty"`
+	Scope     string         `json:"scope,omitempty"`
+	Gen       uint64         `json:"gen,omitempty"`
+}
+
+// jwtNumericDate is the number of seconds since the epoch, fractions of a second are truncated.
+type jwtNumericDate int64
+
+// UnmarshalJSON accepts an integer or a fractional number.
+func (d *jwtNumericDate) UnmarshalJSON(b []byte) error {
+	f, err := strconv.ParseFloat(string(b), 64)
+	if err != nil {
+		return fmt.Errorf("invalid numeric date %s", b)
+	}
+	*d = jwtNumericDate(f)
+	return nil
+}
+
+// time returns the numeric date as time, zero is the zero time.
+func (d jwtNumericDate) time() time.Time {
+	if d == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(d), 0).UTC()
+}
+
+// jwtAudience is the audience of the JWT, it is a string or an array with a single string.
+type jwtAudience string
+
+// UnmarshalJSON accepts a string or an array with a single string.
+func (a *jwtAudience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = jwtAudience(s)
+		return nil
+	}
+	var l []string
+	if err := json.Unmarshal(b, &l); err != nil {
+		return fmt.Errorf("invalid audience %s", b)
+	}
+	if len(l) > 1 {
+		return fmt.Errorf("multiple audiences are not supported")
+	}
+	if len(l) == 1 {
+		*a = jwtAudience(l[0])
+	}
+	return nil
+}
+
+// marshalJWTToken creates the JWT from the token signed by the signer.
+func marshalJWTToken(t *token, signer *jwtSigner) (string, error) {
+	if len(t.claims.values) > 0 {
+		return "", fmt.Errorf("additional claims are not supported by JWT")
+	}
+	if err := checkScopes(t.scopes); err != nil {
+		return "", err
+	}
+
+	header, err := json.Marshal(jwtHeader{Algorithm: signer.alg, Type: "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	claims := jwtClaims{
+		Subject:   t.userID,
+		Name:      t.userName,
+		Role:      t.roleID,
+		ExpiresAt: jwtNumericDate(t.expiredAt.Unix()),
+		Issuer:    t.issuer,
+		Audience:  jwtAudience(t.audience),
+		ID:        t.tokenID,
+		Scope:     strings.Join(t.scopes, " "),
+		Gen:       t.generation,
+	}
+	if !t.issuedAt.IsZero() {
+		claims.IssuedAt = jwtNumericDate(t.issuedAt.Unix())
+	}
+	if !t.notBefore.IsZero() {
+		claims.NotBefore = jwtNumericDate(t.notBefore.Unix())
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	data := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature := signer.sign([]byte(data))
+
+	return data + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// unmarshalJWTToken extracts the token from the JWT verified by the signer.
+func unmarshalJWTToken(data string, verifier *jwtSigner) (*token, error) {
+	parts := strings.Split(data, ".")
+	if len(parts) != 3 {
+		return nil, newTokenError(ErrMalformed, "JWT must have 3 parts, got %d", len(parts))
+	}
+
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, asTokenError(ErrMalformed, err)
+	}
+	var header jwtHeader
+	if err = json.Unmarshal(rawHeader, &header); err != nil {
+		return nil, asTokenError(ErrMalformed, err)
+	}
+	if header.Algorithm != verifier.alg {
+		return nil, newTokenError(ErrUnsupportedVersion, "JWT algorithm %q, expected %q", header.Algorithm, verifier.alg)
+	}
+	if len(header.Critical) > 0 {
+		return nil, newTokenError(ErrUnsupportedVersion, "JWT critical header parameters %v are not supported", header.Critical)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, asTokenError(ErrMalformed, err)
+	}
+	if !verifier.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, newTokenError(ErrTampered, "incorrect JWT signature")
+	}
+
+	rawClaims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, asTokenError(ErrMalformed, err)
+	}
+	var claims jwtClaims
+	if err = json.Unmarshal(rawClaims, &claims); err != nil {
+		return nil, asTokenError(ErrMalformed, err)
+	}
+	if claims.ExpiresAt == 0 {
+		return nil, newTokenError(ErrInvalidClaims, "no expiration time")
+	}
+
+	t := &token{
+		userID:     claims.Subject,
+		userName:   claims.Name,
+		roleID:     claims.Role,
+		expiredAt:  claims.ExpiresAt.time(),
+		issuedAt:   claims.IssuedAt.time(),
+		notBefore:  claims.NotBefore.time(),
+		issuer:     claims.Issuer,
+		audience:   string(claims.Audience),
+		tokenID:    claims.ID,
+		scopes:     strings.Fields(claims.Scope),
+		generation: claims.Gen,
+	}
+
+	return t, nil
+}
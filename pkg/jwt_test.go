@@ -0,0 +1,197 @@
+package tokeninjector
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/prorochestvo/tokeninjector/internal"
+	"github.com/twinj/uuid"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarshalJWT(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secretKey := append(uuid.NewV4().Bytes(), append(uuid.NewV4().Bytes(), append(uuid.NewV4().Bytes(), uuid.NewV4().Bytes()...)...)...)
+
+	testCases := []struct {
+		alg        string
+		signKey    any
+		verifyKey  any
+		otherKey   any
+		otherError error
+	}{
+		{alg: JWTAlgorithmHS256, signKey: secretKey[:32], verifyKey: secretKey[:32], otherKey: secretKey[32:]},
+		{alg: JWTAlgorithmHS384, signKey: secretKey[:48], verifyKey: secretKey[:48], otherKey: secretKey[16:]},
+		{alg: JWTAlgorithmHS512, signKey: secretKey, verifyKey: secretKey, otherKey: append(secretKey[1:], 0)},
+		{alg: JWTAlgorithmEdDSA, signKey: privateKey, verifyKey: publicKey},
+	}
+	for _, tc := range testCases {
+		expectedUserID := uuid.NewV4().String()
+		expectedUserName := uuid.NewV4().String()
+		expectedRoleID := rand.Uint64()
+		expectedExpiredAt := time.Unix(time.Now().Add(time.Hour).Unix(), 0).UTC()
+		expectedAudience := uuid.NewV4().String()
+
+		expectedScopes := []string{"orders:*", "users:read"}
+
+		data, err := MarshalJWT(expectedUserID, expectedUserName, expectedRoleID, expectedExpiredAt, tc.alg, tc.signKey, WithAudience(expectedAudience), WithScopes(expectedScopes...), WithGeneration(3))
+		if err != nil {
+			t.Fatalf("%s: %s", tc.alg, err.Error())
+		}
+
+		tkn, err := UnmarshalJWT(data, tc.alg, tc.verifyKey, WithExpectedAudience(expectedAudience))
+		if err != nil {
+			t.Fatalf("%s: %s", tc.alg, err.Error())
+		}
+		if a := tkn.UserID(); a != expectedUserID {
+			t.Errorf("%s: incorrect token userId, got %s, expected %s", tc.alg, a, expectedUserID)
+		}
+		if a := tkn.UserName(); a != expectedUserName {
+			t.Errorf("%s: incorrect token userName, got %s, expected %s", tc.alg, a, expectedUserName)
+		}
+		if a := tkn.UserRoleID(); a != expectedRoleID {
+			t.Errorf("%s: incorrect token userRoleId, got %d, expected %d", tc.alg, a, expectedRoleID)
+		}
+		if a := tkn.ExpiredAt(); !a.Equal(expectedExpiredAt) {
+			t.Errorf("%s: incorrect token expiredAt, got %s, expected %s", tc.alg, a, expectedExpiredAt)
+		}
+		if a := tkn.Scopes(); !slices.Equal(a, expectedScopes) {
+			t.Errorf("%s: incorrect token scopes, got %v, expected %v", tc.alg, a, expectedScopes)
+		}
+		if a := tkn.Generation(); a != 3 {
+			t.Errorf("%s: incorrect token generation, got %d", tc.alg, a)
+		}
+		if len(tkn.TokenID()) == 0 || tkn.IssuedAt().IsZero() {
+			t.Errorf("%s: token has no default registered claims", tc.alg)
+		}
+
+		if tc.otherKey != nil {
+			if _, err = UnmarshalJWT(data, tc.alg, tc.otherKey); !errors.Is(err, ErrTampered) {
+				t.Errorf("%s: token of another key was not rejected, got %v", tc.alg, err)
+			}
+		}
+	}
+}
+
+func TestUnmarshalJWT_Compatibility(t *testing.T) {
+	secretKey := []byte("0123456789abcdef0123456789abcdef")
+	exp := time.Now().Add(time.Hour).Unix()
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"typ":"JWT","alg":"HS256"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"sub":"42","name":"John","role":7,"exp":%d.5,"aud":["api"],"custom":true}`, exp)))
+	h := hmac.New(sha256.New, secretKey)
+	h.Write([]byte(header + "." + payload))
+	data := header + "." + payload + "." + base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+
+	tkn, err := UnmarshalJWT(data, JWTAlgorithmHS256, secretKey, WithExpectedAudience("api"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tkn.UserID() != "42" || tkn.UserName() != "John" || tkn.UserRoleID() != 7 || tkn.ExpiredAt().Unix() != exp {
+		t.Errorf("incorrect token, got %s %s %d %s", tkn.UserID(), tkn.UserName(), tkn.UserRoleID(), tkn.ExpiredAt())
+	}
+}
+
+func TestUnmarshalJWT_Rejected(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiredAt := time.Now().Add(time.Hour)
+
+	signed, err := MarshalJWT("id", "name", 1, expiredAt, JWTAlgorithmEdDSA, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// algorithm "none" with an empty signature
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + strings.Split(signed, ".")[1] + "."
+	if _, err = UnmarshalJWT(none, JWTAlgorithmEdDSA, publicKey); err == nil {
+		t.Errorf("algorithm none was accepted")
+	}
+	if _, err = UnmarshalJWT(signed, "none", publicKey); err == nil {
+		t.Errorf("algorithm none was accepted as the expected algorithm")
+	}
+
+	// algorithm confusion: HMAC with the public key as the secret key
+	confused, err := MarshalJWT("id", "name", 1, expiredAt, JWTAlgorithmHS256, []byte(publicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = UnmarshalJWT(confused, JWTAlgorithmEdDSA, publicKey); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("algorithm confusion was not rejected, got %v", err)
+	}
+	if _, err = UnmarshalJWT(confused, JWTAlgorithmHS256, publicKey); err == nil {
+		t.Errorf("public key was accepted as HMAC secret key")
+	}
+
+	if _, err = MarshalJWT("id", "name", 1, expiredAt, JWTAlgorithmHS256, []byte("short")); err == nil {
+		t.Errorf("short HMAC secret key was accepted")
+	}
+	if _, err = MarshalJWT("id", "name", 1, expiredAt, JWTAlgorithmEdDSA, privateKey, WithStringClaim(1, "x")); err == nil {
+		t.Errorf("additional claims were accepted")
+	}
+
+	expired, err := MarshalJWT("id", "name", 1, time.Now().Add(-time.Hour), JWTAlgorithmEdDSA, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = UnmarshalJWT(expired, JWTAlgorithmEdDSA, publicKey); !errors.Is(err, ErrExpired) {
+		t.Errorf("expired token was not rejected, got %v", err)
+	}
+	if _, err = UnmarshalJWT(signed+".x", JWTAlgorithmEdDSA, publicKey); !errors.Is(err, ErrMalformed) {
+		t.Errorf("malformed token was not rejected, got %v", err)
+	}
+}
+
+func TestTokenHandler_HeaderBearerJWT(t *testing.T) {
+	secretKey := uuid.NewV4().Bytes()
+	jwtKey := append(uuid.NewV4().Bytes(), uuid.NewV4().Bytes()...)
+	userID := uuid.NewV4().String()
+
+	headerValue, err := MarshalJWT(userID, uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Hour), JWTAlgorithmHS256, jwtKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(internal.HeaderAuthorization, fmt.Sprintf("%s %s", internal.AuthMethodBearer, headerValue))
+
+	h, err := TokenHandler(secretKey, uuid.NewV4().String(), func(w http.ResponseWriter, r *http.Request) {
+		v, err := ExtractToken(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(v.UserID()))
+	}, WithJWTBearer(JWTAlgorithmHS256, jwtKey))
+	if err != nil {
+		t.Fatalf("could not create nextHandler; details: %s", err.Error())
+	}
+
+	h(res, req)
+	if res.Code != http.StatusOK {
+		t.Errorf("incorrect response code, got %d", res.Code)
+	}
+	if s := res.Body.String(); s != userID {
+		t.Errorf("incorrect response body, got %s", s)
+	}
+
+	if _, err = TokenHandler(secretKey, "", nil, WithJWTBearer("none", nil)); err == nil {
+		t.Errorf("middleware accepted algorithm none")
+	}
+}
@@ -0,0 +1,209 @@
+package tokeninjector
+
+import (
+	"fmt"
+	"sync"
+)
+
+// KeyStatus is the status of a secret key in the KeyRing.
+type KeyStatus uint8
+
+const (
+	// KeyActive marks the key used to issue new tokens, a key ring holds at most one active key.
+	KeyActive KeyStatus = iota + 1
+	// KeyVerifyOnly marks the key that is no longer used to issue tokens, but still accepted for verification.
+	KeyVerifyOnly
+	// KeyRetired marks the key whose tokens are rejected.
+	KeyRetired
+)
+
+// String returns the name of the key status.
+func (s KeyStatus) String() string {
+	switch s {
+	case KeyActive:
+		return "active"
+	case KeyVerifyOnly:
+		return "verify-only"
+	case KeyRetired:
+		return "retired"
+	default:
+		return fmt.Sprintf("KeyStatus(%d)", uint8(s))
+	}
+}
+
+// maxKeyIDLength is the maximum length of the key id, the length is stored in one byte of the token header.
+const maxKeyIDLength = 0xFF
+
+// KeyRing is a set of secret keys used to rotate the secret key without invalidating all issued tokens.
+// Each key has an id, which is stored in the token header, and a status (active, verify-only, retired).
+// KeyRing is safe for concurrent use.
+type KeyRing struct {
+	mutex        sync.RWMutex
+	keys         map[string]*ringKey
+	order        []string
+	active       string
+	rejectLegacy bool
+}
+
+// ringKey is a secret key with its status.
+type ringKey struct {
+	secretKey []byte
+	status    KeyStatus
+}
+
+// NewKeyRing creates an empty key ring.
+func NewKeyRing() *KeyRing {
+	return &KeyRing{keys: make(map[string]*ringKey)}
+}
+
+// newSingleKeyRing creates a key ring with one active key and an empty key id.
+func newSingleKeyRing(secretKey []byte) (*KeyRing, error) {
+	r := NewKeyRing()
+	if err := r.AddKey("", secretKey, KeyActive); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// AddKey adds the secret key with the id and status to the key ring.
+// If the status is KeyActive, the previously active key becomes verify-only.
+func (r *KeyRing) AddKey(id string, secretKey []byte, status KeyStatus) error {
+	if len(id) > maxKeyIDLength {
+		return fmt.Errorf("key id is too long, got %d, maximum %d", len(id), maxKeyIDLength)
+	}
+	if l := len(secretKey); l != 16 && l != 24 && l != 32 {
+		return fmt.Errorf("invalid secret key size %d, expected 16, 24 or 32", l)
+	}
+	if status < KeyActive || status > KeyRetired {
+		return fmt.Errorf("invalid key status %s", status)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.keys[id]; ok {
+		return fmt.Errorf("key %q already exists", id)
+	}
+
+	r.keys[id] = &ringKey{secretKey: append([]byte(nil), secretKey...)}
+	r.order = append(r.order, id)
+	r.setStatus(id, status)
+
+	return nil
+}
+
+// SetStatus changes the status of the key with the id.
+// If the status is KeyActive, the previously active key becomes verify-only.
+func (r *KeyRing) SetStatus(id string, status KeyStatus) error {
+	if status < KeyActive || status > KeyRetired {
+		return fmt.Errorf("invalid key status %s", status)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.keys[id]; !ok {
+		return fmt.Errorf("key %q not found", id)
+	}
+
+	r.setStatus(id, status)
+
+	return nil
+}
+
+// Status returns the status of the key with the id.
+func (r *KeyRing) Status(id string) (KeyStatus, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	k, ok := r.keys[id]
+	if !ok {
+		return 0, false
+	}
+	return k.status, true
+}
+
+// ActiveKeyID returns the id of the key used to issue new tokens.
+func (r *KeyRing) ActiveKeyID() (string, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	k, ok := r.keys[r.active]
+	if !ok || k.status != KeyActive {
+		return "", false
+	}
+	return r.active, true
+}
+
+// SetAcceptLegacyTokens controls whether tokens in the v1 format (AES-CFB + CRC32) are still accepted,
+// they are accepted by default. It should be switched off once every v1 token issued before the migration
+// to v2 has expired, the setting can be changed at any time.
+func (r *KeyRing) SetAcceptLegacyTokens(accept bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.rejectLegacy = !accept
+}
+
+// AcceptsLegacyTokens reports whether tokens in the v1 format are accepted, see SetAcceptLegacyTokens.
+func (r *KeyRing) AcceptsLegacyTokens() bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return !r.rejectLegacy
+}
+
+// setStatus changes the status of the existing key, the caller must hold the write lock.
+func (r *KeyRing) setStatus(id string, status KeyStatus) {
+	if status == KeyActive {
+		if k, ok := r.keys[r.active]; ok && r.active != id && k.status == KeyActive {
+			k.status = KeyVerifyOnly
+		}
+		r.active = id
+	}
+	r.keys[id].status = status
+}
+
+// activeKey returns the id and the secret key used to issue new tokens.
+func (r *KeyRing) activeKey() (string, []byte, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	k, ok := r.keys[r.active]
+	if !ok || k.status != KeyActive {
+		return "", nil, fmt.Errorf("key ring has no active key")
+	}
+	return r.active, k.secretKey, nil
+}
+
+// verificationKey returns the secret key with the id, if it is accepted for verification.
+func (r *KeyRing) verificationKey(id string) ([]byte, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	k, ok := r.keys[id]
+	if !ok {
+		return nil, newTokenError(ErrUnknownKey, "key %q is not in the key ring", id)
+	}
+	if k.status == KeyRetired {
+		return nil, newTokenError(ErrUnknownKey, "key %q is retired", id)
+	}
+	return k.secretKey, nil
+}
+
+// verificationKeys returns all secret keys accepted for verification, the active key comes first.
+func (r *KeyRing) verificationKeys() [][]byte {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	keys := make([][]byte, 0, len(r.order))
+	if k, ok := r.keys[r.active]; ok && k.status == KeyActive {
+		keys = append(keys, k.secretKey)
+	}
+	for _, id := range r.order {
+		if k := r.keys[id]; k.status == KeyVerifyOnly {
+			keys = append(keys, k.secretKey)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,94 @@
+package tokeninjector
+
+import (
+	"github.com/twinj/uuid"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestKeyRing_Rotation(t *testing.T) {
+	userID := uuid.NewV4().String()
+	userName := uuid.NewV4().String()
+	roleID := rand.Uint64()
+	expiredAt := time.Now().Add(time.Hour)
+
+	ring := NewKeyRing()
+	if err := ring.AddKey("k1", uuid.NewV4().Bytes(), KeyActive); err != nil {
+		t.Fatal(err)
+	}
+
+	oldToken, err := MarshalWithKeyRing(userID, userName, roleID, expiredAt, ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ring.AddKey("k2", uuid.NewV4().Bytes(), KeyActive); err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := ring.ActiveKeyID(); !ok || id != "k2" {
+		t.Fatalf("incorrect active key, got %s", id)
+	}
+	if s, ok := ring.Status("k1"); !ok || s != KeyVerifyOnly {
+		t.Fatalf("incorrect status of the previous key, got %s", s)
+	}
+
+	newToken, err := MarshalWithKeyRing(userID, userName, roleID, expiredAt, ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range []string{oldToken, newToken} {
+		actualUserID, _, _, _, err := UnmarshalWithKeyRing(data, ring)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actualUserID != userID {
+			t.Errorf("incorrect token userId, got %s, expected %s", actualUserID, userID)
+		}
+	}
+
+	if err = ring.SetStatus("k1", KeyRetired); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, _, err = UnmarshalWithKeyRing(oldToken, ring); err == nil {
+		t.Errorf("token of the retired key was accepted")
+	}
+	if _, _, _, _, err = UnmarshalWithKeyRing(newToken, ring); err != nil {
+		t.Errorf("token of the active key was rejected; details: %s", err.Error())
+	}
+
+	other := NewKeyRing()
+	if err = other.AddKey("k3", uuid.NewV4().Bytes(), KeyActive); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, _, err = UnmarshalWithKeyRing(newToken, other); err == nil {
+		t.Errorf("token of the unknown key was accepted")
+	}
+}
+
+func TestKeyRing_AddKey(t *testing.T) {
+	ring := NewKeyRing()
+
+	if _, err := MarshalWithKeyRing("id", "name", 0, time.Now(), ring); err == nil {
+		t.Errorf("empty key ring issued a token")
+	}
+	if err := ring.AddKey("k1", []byte("short"), KeyActive); err == nil {
+		t.Errorf("invalid secret key was accepted")
+	}
+	if err := ring.AddKey(string(make([]byte, maxKeyIDLength+1)), uuid.NewV4().Bytes(), KeyActive); err == nil {
+		t.Errorf("too long key id was accepted")
+	}
+	if err := ring.AddKey("k1", uuid.NewV4().Bytes(), KeyVerifyOnly); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.AddKey("k1", uuid.NewV4().Bytes(), KeyActive); err == nil {
+		t.Errorf("duplicate key id was accepted")
+	}
+	if _, ok := ring.ActiveKeyID(); ok {
+		t.Errorf("verify-only key became active")
+	}
+	if err := ring.SetStatus("k2", KeyActive); err == nil {
+		t.Errorf("status of the unknown key was changed")
+	}
+}
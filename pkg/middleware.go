@@ -3,6 +3,7 @@ package tokeninjector
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/prorochestvo/tokeninjector/internal"
 	"net/http"
 	"strings"
@@ -10,21 +11,83 @@ import (
 )
 
 // TokenHandler is a middleware that extracts the user ID from the request and adds it to the request context.
-// The token is extracted from the cookie and the header.
+// The token is extracted from the cookie and the header, see ExtractToken,
+// the credentials of the Authorization header are returned by BasicCredentials and BearerCredential.
 //   - secretKey: the secret key used to crypt and decrypt the token.
 //   - cookieName: the name of the cookie that contains the token.
-//   - contextBasicMethodKey: the key used to store the basic method token in the context.
-//   - contextBearerMethodKey: the key used to store the bearer method token in the context.
 //   - nextFunc: the next handler in the chain.
+//   - opts: the optional settings of the middleware.
 //
-// IMPORTANT: does not return an error if the user ID is not found.
+// IMPORTANT: does not return an error if the user ID is not found, unless WithRequireToken is set.
 func TokenHandler(
 	secretKey []byte,
 	cookieName string,
-	contextBasicMethodKey string,
-	contextBearerMethodKey string,
 	nextFunc http.HandlerFunc,
+	opts ...HandlerOption,
 ) (http.HandlerFunc, error) {
+	ring, err := newSingleKeyRing(secretKey)
+	if err != nil {
+		return nil, err
+	}
+	return KeyRingHandler(ring, cookieName, nextFunc, opts...)
+}
+
+// KeyRingHandler is a middleware like TokenHandler, but the token is decrypted with the key ring,
+// so the secret key can be rotated without invalidating the issued tokens.
+//   - ring: the key ring, the key is selected by the id stored in the token header.
+//
+// IMPORTANT: does not return an error if the user ID is not found, unless WithRequireToken is set.
+func KeyRingHandler(
+	ring *KeyRing,
+	cookieName string,
+	nextFunc http.HandlerFunc,
+	opts ...HandlerOption,
+) (http.HandlerFunc, error) {
+	if ring == nil {
+		return nil, errors.New("key ring is not defined")
+	}
+	codec := tokenCodec{
+		decode: func(data string) (*token, bool, error) { return unmarshalToken(data, ring) },
+		encode: func(t *token) (string, error) { return marshalToken(t, ring) },
+	}
+	return newTokenHandler(codec, cookieName, nextFunc, opts)
+}
+
+// tokenCodec decodes the token strings accepted by the middleware and encodes the re-issued tokens.
+// The encode function is nil for the middleware that can only verify tokens.
+type tokenCodec struct {
+	decode func(data string) (t *token, outdated bool, err error)
+	encode func(t *token) (string, error)
+}
+
+// newTokenHandler creates the middleware of TokenHandler with the codec of the token strings.
+func newTokenHandler(
+	codec tokenCodec,
+	cookieName string,
+	nextFunc http.HandlerFunc,
+	opts []HandlerOption,
+) (http.HandlerFunc, error) {
+	options := newHandlerOptions(opts)
+	if options.reissueCookie != nil && codec.encode == nil {
+		return nil, errors.New("cookie reissue requires a key to issue tokens")
+	}
+	if options.sliding != nil && codec.encode == nil {
+		return nil, errors.New("sliding expiration requires a key to issue tokens")
+	}
+	if err := options.sliding.check(); err != nil {
+		return nil, err
+	}
+	cookieOptions, err := newReissueCookieOptions(options, cookieName)
+	if err != nil {
+		return nil, err
+	}
+	validation := newValidateOptions(options.validation)
+	var jwtVerifier *jwtSigner
+	if options.jwtBearer {
+		if jwtVerifier, err = newJWTSigner(options.jwtAlgorithm, options.jwtKey, false); err != nil {
+			return nil, err
+		}
+	}
 	var extractCookieToken = func(r *http.Request) (accessToken string) {
 		if len(cookieName) == 0 {
 			return
@@ -34,49 +97,195 @@ func TokenHandler(
 		}
 		return
 	}
-	var extractHeaderToken = func(r *http.Request) (method string, refreshToken string) {
-		header := strings.TrimSpace(r.Header.Get(internal.HeaderAuthorization))
-		if parts := strings.SplitN(header, " ", 2); len(parts) == 2 {
-			method = parts[0]
-			refreshToken = strings.TrimSpace(parts[1])
+	var decodeToken = func(data string) (*token, bool, error) {
+		t, outdated, err := codec.decode(data)
+		if err == nil {
+			err = validateToken(t, validation)
 		}
-		return
+		if err != nil {
+			return nil, false, err
+		}
+		return t, outdated, nil
+	}
+	var decodeBearerToken = func(data string) (Token, error) {
+		// the token of the package is base64 encoded, so it never looks like a JWT
+		if jwtVerifier != nil && strings.Count(data, ".") == 2 {
+			t, err := unmarshalJWTToken(data, jwtVerifier)
+			if err == nil {
+				err = validateToken(t, validation)
+			}
+			if err != nil {
+				return nil, err
+			}
+			return t, nil
+		}
+		if options.bearerToken {
+			t, _, err := decodeToken(data)
+			if err != nil {
+				return nil, err
+			}
+			return t, nil
+		}
+		return nil, nil
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
+		if options.responder != nil {
+			ctx = contextWithResponder(ctx, options.responder)
+		}
+
+		var cookieToken, headerToken Token
+		var cookieErr, headerErr error
+		var cookie *token
+		var outdated bool
 
 		if accessToken := extractCookieToken(r); len(accessToken) > 0 {
-			userId, userName, roleId, expiredAt, err := Unmarshal(accessToken, secretKey)
-			if err == nil && len(userId) > 0 && expiredAt.After(time.Now()) {
-				t := &token{
-					userID:    userId,
-					userName:  userName,
-					roleID:    roleId,
-					expiredAt: expiredAt,
+			if cookie, outdated, cookieErr = decodeToken(accessToken); cookieErr == nil {
+				cookieToken = cookie
+			}
+		}
+
+		// the authentication scheme is case-insensitive (RFC 7235)
+		if method, credential := parseAuthorization(r); len(credential) > 0 {
+			switch {
+			case strings.EqualFold(method, internal.AuthMethodBasic):
+				username, password, err := decodeBasicCredentials(credential)
+				if err == nil {
+					ctx = context.WithValue(ctx, contextKeyBasic{}, basicCredentials{username: username, password: password})
+				}
+				if options.basicVerifier == nil {
+					break
 				}
-				ctx = context.WithValue(ctx, internal.ContextKeyToken, t)
+				if err != nil {
+					headerErr = err
+				} else if headerToken, headerErr = verifyCredentials(username, password, options.basicVerifier); headerErr == nil {
+					// the token of the verifier passes the same checks as the decoded tokens, e.g. the revocation
+					if headerErr = validateToken(tokenOf(headerToken), validation); headerErr != nil {
+						headerToken = nil
+					}
+				}
+			case strings.EqualFold(method, internal.AuthMethodBearer):
+				ctx = context.WithValue(ctx, contextKeyBearer{}, credential)
+				headerToken, headerErr = decodeBearerToken(credential)
 			}
 		}
 
-		if method, refreshToken := extractHeaderToken(r); len(refreshToken) > 0 {
-			switch method {
-			case internal.AuthMethodBasic:
-				ctx = context.WithValue(ctx, contextBasicMethodKey, refreshToken)
-			case internal.AuthMethodBearer:
-				ctx = context.WithValue(ctx, contextBearerMethodKey, refreshToken)
+		if t, err := selectToken(cookieToken, cookieErr, headerToken, headerErr, options.precedence); t != nil {
+			if t == cookieToken {
+				options.sliding.limit(cookie)
+				if renewed := options.sliding.renew(cookie, time.Now()); renewed != nil {
+					t = renewed
+					reissueCookie(w, renewed, codec, cookieOptions)
+				} else if outdated && options.reissueCookie != nil {
+					reissueCookie(w, cookie, codec, cookieOptions)
+				}
 			}
+			ctx = WithToken(ctx, t)
+		} else if err != nil {
+			ctx = withTokenError(ctx, err)
+		}
+
+		if options.requireToken {
+			RequireToken(nextFunc)(w, r.WithContext(ctx))
+			return
 		}
 
 		nextFunc(w, r.WithContext(ctx))
 	}, nil
 }
 
-// ExtractToken extracts the token from the context.
-// If the token is not found, an error is returned.
-func ExtractToken(ctx context.Context) (Token, error) {
-	t, ok := ctx.Value(internal.ContextKeyToken).(Token)
-	if !ok {
-		return nil, errors.New("token not found")
+// selectToken chooses the token of the cookie or the Authorization header according to the precedence.
+// If none of them is valid, the error of the preferred one is returned.
+func selectToken(cookieToken Token, cookieErr error, bearerToken Token, bearerErr error, precedence TokenPrecedence) (Token, error) {
+	switch {
+	case cookieToken != nil && bearerToken != nil:
+		if precedence == RejectConflict && cookieToken.UserID() != bearerToken.UserID() {
+			return nil, newTokenError(ErrTokenConflict, "cookie user %q, bearer user %q", cookieToken.UserID(), bearerToken.UserID())
+		}
+		if precedence == PreferBearer {
+			return bearerToken, nil
+		}
+		return cookieToken, nil
+	case cookieToken != nil:
+		return cookieToken, nil
+	case bearerToken != nil:
+		return bearerToken, nil
+	case precedence == PreferBearer && bearerErr != nil:
+		return nil, bearerErr
+	case cookieErr != nil:
+		return nil, cookieErr
+	default:
+		return nil, bearerErr
+	}
+}
+
+// newReissueCookieOptions returns the options of the cookies re-issued and renewed by the middleware:
+// the options of WithCookieOptions, the attributes of the WithCookieReissue template or the secure defaults.
+// The options are checked like IssueCookie does, so the misconfiguration is reported on the creation.
+func newReissueCookieOptions(options *handlerOptions, cookieName string) (CookieOptions, error) {
+	var opts CookieOptions
+	switch {
+	case options.cookie != nil:
+		opts = *options.cookie
+		if len(opts.Name) > 0 && opts.Name != cookieName {
+			return CookieOptions{}, fmt.Errorf("cookie options name %s differs from the cookie name %s", opts.Name, cookieName)
+		}
+	case options.reissueCookie != nil:
+		opts = CookieOptions{
+			Path:     options.reissueCookie.Path,
+			Domain:   options.reissueCookie.Domain,
+			SameSite: options.reissueCookie.SameSite,
+			Insecure: !options.reissueCookie.Secure,
+		}
+	}
+	opts.Name = cookieName
+	if options.reissueCookie == nil && options.sliding == nil {
+		return opts, nil
+	}
+	if _, err := newCookie(opts, ""); err != nil {
+		return CookieOptions{}, err
+	}
+	return opts, nil
+}
+
+// reissueCookie sets the cookie with the token encoded with the current key and format, like IssueCookie.
+// The cookie is left as is if the token could not be encoded, it is still valid.
+func reissueCookie(w http.ResponseWriter, t *token, codec tokenCodec, opts CookieOptions) {
+	maxAge := int(time.Until(t.expiredAt).Seconds())
+	if maxAge <= 0 {
+		return
+	}
+	value, err := codec.encode(t)
+	if err != nil {
+		return
+	}
+	c, err := newCookie(opts, value)
+	if err != nil {
+		return
+	}
+	c.Expires = t.expiredAt.UTC()
+	c.MaxAge = maxAge
+
+	_ = setCookie(w, c, opts.Partitioned)
+}
+
+// verifyBasicCredentials decodes the credential of the Basic method and verifies it with the verifier.
+func verifyBasicCredentials(credential string, verifier CredentialVerifier) (Token, error) {
+	username, password, err := decodeBasicCredentials(credential)
+	if err != nil {
+		return nil, err
+	}
+	return verifyCredentials(username, password, verifier)
+}
+
+// verifyCredentials verifies the username and password with the verifier.
+func verifyCredentials(username string, password string, verifier CredentialVerifier) (Token, error) {
+	t, err := verifier.Verify(username, password)
+	if err != nil {
+		return nil, asTokenError(ErrInvalidCredentials, err)
+	}
+	if t == nil {
+		return nil, newTokenError(ErrInvalidCredentials, "verifier returned no token")
 	}
 	return t, nil
 }
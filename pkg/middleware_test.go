@@ -1,6 +1,8 @@
 package tokeninjector
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"github.com/prorochestvo/tokeninjector/internal"
 	"github.com/twinj/uuid"
@@ -29,25 +31,22 @@ func TestTokenHandler_HeaderCookie_ActiveToken(t *testing.T) {
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
 	req.AddCookie(&http.Cookie{Name: cookieName, Value: cookieValue, HttpOnly: true})
 
-	basicMethodKey := uuid.NewV4().String()
-	bearerMethodKey := uuid.NewV4().String()
-
-	h, err := TokenHandler(secretKey, cookieName, basicMethodKey, bearerMethodKey, func(w http.ResponseWriter, r *http.Request) {
+	h, err := TokenHandler(secretKey, cookieName, func(w http.ResponseWriter, r *http.Request) {
 		defer func(Body io.ReadCloser) {
 			if e := Body.Close(); e != nil {
 				_, _ = w.Write([]byte(e.Error()))
 			}
 		}(r.Body)
-		if _, ok := r.Context().Value(basicMethodKey).(string); ok {
+		if _, _, ok := BasicCredentials(r.Context()); ok {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		if _, ok := r.Context().Value(bearerMethodKey).(string); ok {
+		if _, ok := BearerCredential(r.Context()); ok {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		v, ok := r.Context().Value(internal.ContextKeyToken).(Token)
-		if !ok {
+		v, err := ExtractToken(r.Context())
+		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
@@ -87,24 +86,21 @@ func TestTokenHandler_HeaderCookie_InactiveToken(t *testing.T) {
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
 	req.AddCookie(&http.Cookie{Name: cookieName, Value: cookieValue, HttpOnly: true})
 
-	basicMethodKey := uuid.NewV4().String()
-	bearerMethodKey := uuid.NewV4().String()
-
-	h, err := TokenHandler(secretKey, cookieName, basicMethodKey, bearerMethodKey, func(w http.ResponseWriter, r *http.Request) {
+	h, err := TokenHandler(secretKey, cookieName, func(w http.ResponseWriter, r *http.Request) {
 		defer func(Body io.ReadCloser) {
 			if e := Body.Close(); e != nil {
 				_, _ = w.Write([]byte(e.Error()))
 			}
 		}(r.Body)
-		if _, ok := r.Context().Value(basicMethodKey).(string); ok {
+		if _, _, ok := BasicCredentials(r.Context()); ok {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		if _, ok := r.Context().Value(bearerMethodKey).(string); ok {
+		if _, ok := BearerCredential(r.Context()); ok {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		if _, ok := r.Context().Value(internal.ContextKeyToken).(Token); ok {
+		if _, err := ExtractToken(r.Context()); err == nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
@@ -123,36 +119,35 @@ func TestTokenHandler_HeaderCookie_InactiveToken(t *testing.T) {
 func TestTokenHandler_HeaderBasicAuthorization(t *testing.T) {
 	secretKey := uuid.NewV4().Bytes()
 	cookieName := uuid.NewV4().String()
-	headerValue := uuid.NewV4().String()
+	username := uuid.NewV4().String()
+	password := uuid.NewV4().String() + ":" + uuid.NewV4().String()
+	headerValue := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
 
 	res := httptest.NewRecorder()
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
 	req.Header.Set(internal.HeaderAuthorization, fmt.Sprintf("%s %s", internal.AuthMethodBasic, headerValue))
 
-	basicMethodKey := uuid.NewV4().String()
-	bearerMethodKey := uuid.NewV4().String()
-
-	h, err := TokenHandler(secretKey, cookieName, basicMethodKey, bearerMethodKey, func(w http.ResponseWriter, r *http.Request) {
+	h, err := TokenHandler(secretKey, cookieName, func(w http.ResponseWriter, r *http.Request) {
 		defer func(Body io.ReadCloser) {
 			if e := Body.Close(); e != nil {
 				_, _ = w.Write([]byte(e.Error()))
 			}
 		}(r.Body)
-		if _, ok := r.Context().Value(internal.ContextKeyToken).(Token); ok {
+		if _, err := ExtractToken(r.Context()); err == nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		if _, ok := r.Context().Value(bearerMethodKey).(Token); ok {
+		if _, ok := BearerCredential(r.Context()); ok {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		v, ok := r.Context().Value(basicMethodKey).(string)
-		if !ok || len(v) == 0 {
+		u, p, ok := BasicCredentials(r.Context())
+		if !ok || u != username || p != password {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte(v))
+		_, _ = w.Write([]byte(u))
 	})
 	if err != nil {
 		t.Errorf("could not create nextHandler; details: %s", err.Error())
@@ -162,9 +157,18 @@ func TestTokenHandler_HeaderBasicAuthorization(t *testing.T) {
 	if res.Code != http.StatusOK {
 		t.Errorf("incorrect response code, got %d", res.Code)
 	}
-	if s := res.Body.String(); !strings.Contains(s, headerValue) {
+	if s := res.Body.String(); s != username {
 		t.Errorf("incorrect response body, got %s", s)
 	}
+
+	// the malformed credentials are not stored
+	res = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(internal.HeaderAuthorization, fmt.Sprintf("%s %s", internal.AuthMethodBasic, uuid.NewV4().String()))
+	h(res, req)
+	if res.Code != http.StatusInternalServerError {
+		t.Errorf("incorrect response code of the malformed credentials, got %d", res.Code)
+	}
 }
 
 func TestTokenHandler_HeaderBearerAuthorization(t *testing.T) {
@@ -176,24 +180,21 @@ func TestTokenHandler_HeaderBearerAuthorization(t *testing.T) {
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
 	req.Header.Set(internal.HeaderAuthorization, fmt.Sprintf("%s %s", internal.AuthMethodBearer, headerValue))
 
-	basicMethodKey := uuid.NewV4().String()
-	bearerMethodKey := uuid.NewV4().String()
-
-	h, err := TokenHandler(secretKey, cookieName, basicMethodKey, bearerMethodKey, func(w http.ResponseWriter, r *http.Request) {
+	h, err := TokenHandler(secretKey, cookieName, func(w http.ResponseWriter, r *http.Request) {
 		defer func(Body io.ReadCloser) {
 			if e := Body.Close(); e != nil {
 				_, _ = w.Write([]byte(e.Error()))
 			}
 		}(r.Body)
-		if _, ok := r.Context().Value(internal.ContextKeyToken).(Token); ok {
+		if _, err := ExtractToken(r.Context()); err == nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		if _, ok := r.Context().Value(basicMethodKey).(Token); ok {
+		if _, _, ok := BasicCredentials(r.Context()); ok {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		v, ok := r.Context().Value(bearerMethodKey).(string)
+		v, ok := BearerCredential(r.Context())
 		if !ok || len(v) == 0 {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -221,28 +222,64 @@ func TestTokenHandler_Unauthorized(t *testing.T) {
 	res := httptest.NewRecorder()
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
 
-	basicMethodKey := uuid.NewV4().String()
-	bearerMethodKey := uuid.NewV4().String()
-
-	h, err := TokenHandler(secretKey, cookieName, basicMethodKey, bearerMethodKey, func(w http.ResponseWriter, r *http.Request) {
+	h, err := TokenHandler(secretKey, cookieName, func(w http.ResponseWriter, r *http.Request) {
 		defer func(Body io.ReadCloser) {
 			if e := Body.Close(); e != nil {
 				_, _ = w.Write([]byte(e.Error()))
 			}
 		}(r.Body)
-		if _, ok := r.Context().Value(internal.ContextKeyToken).(Token); ok {
+		if _, err := ExtractToken(r.Context()); err == nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		if _, ok := r.Context().Value(basicMethodKey).(string); ok {
+		if _, _, ok := BasicCredentials(r.Context()); ok {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		if _, ok := r.Context().Value(bearerMethodKey).(string); ok {
+		if _, ok := BearerCredential(r.Context()); ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	if err != nil {
+		t.Errorf("could not create nextHandler; details: %s", err.Error())
+	}
+
+	h(res, req)
+	if res.Code != http.StatusOK {
+		t.Errorf("incorrect response code, got %d", res.Code)
+	}
+}
+
+func TestKeyRingHandler_RotatedKey(t *testing.T) {
+	ring := NewKeyRing()
+	if err := ring.AddKey("k1", uuid.NewV4().Bytes(), KeyActive); err != nil {
+		t.Fatal(err)
+	}
+	userID := uuid.NewV4().String()
+
+	cookieName := uuid.NewV4().String()
+	cookieValue, err := MarshalWithKeyRing(userID, uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Hour), ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ring.AddKey("k2", uuid.NewV4().Bytes(), KeyActive); err != nil {
+		t.Fatal(err)
+	}
+
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: cookieName, Value: cookieValue, HttpOnly: true})
+
+	h, err := KeyRingHandler(ring, cookieName, func(w http.ResponseWriter, r *http.Request) {
+		v, err := ExtractToken(r.Context())
+		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(v.UserID()))
 	})
 	if err != nil {
 		t.Errorf("could not create nextHandler; details: %s", err.Error())
@@ -252,4 +289,287 @@ func TestTokenHandler_Unauthorized(t *testing.T) {
 	if res.Code != http.StatusOK {
 		t.Errorf("incorrect response code, got %d", res.Code)
 	}
+	if s := res.Body.String(); s != userID {
+		t.Errorf("incorrect response body, got %s", s)
+	}
+}
+
+func TestKeyRingHandler_CookieReissue(t *testing.T) {
+	ring := NewKeyRing()
+	secretKey := uuid.NewV4().Bytes()
+	if err := ring.AddKey("k1", secretKey, KeyActive); err != nil {
+		t.Fatal(err)
+	}
+	userID := uuid.NewV4().String()
+	expiredAt := time.Now().Add(time.Hour)
+
+	rotatedValue, err := MarshalWithKeyRing(userID, uuid.NewV4().String(), rand.Uint64(), expiredAt, ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyDataset, err := encryptLegacy(convertToByteLegacy([]byte(userID), nil, 0, uint64(expiredAt.Unix())), secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyValue := base64.StdEncoding.EncodeToString(legacyDataset)
+
+	if err = ring.AddKey("k2", uuid.NewV4().Bytes(), KeyActive); err != nil {
+		t.Fatal(err)
+	}
+	currentValue, err := MarshalWithKeyRing(userID, uuid.NewV4().String(), rand.Uint64(), expiredAt, ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cookieName := uuid.NewV4().String()
+	template := http.Cookie{Path: "/", HttpOnly: true, Secure: true, SameSite: http.SameSiteStrictMode}
+
+	h, err := KeyRingHandler(ring, cookieName, func(w http.ResponseWriter, r *http.Request) {
+		if _, err := ExtractToken(r.Context()); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}, WithCookieReissue(template))
+	if err != nil {
+		t.Fatalf("could not create nextHandler; details: %s", err.Error())
+	}
+
+	for name, cookieValue := range map[string]string{"rotated": rotatedValue, "legacy": legacyValue} {
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: cookieName, Value: cookieValue})
+
+		h(res, req)
+		if res.Code != http.StatusOK {
+			t.Fatalf("%s: incorrect response code, got %d", name, res.Code)
+		}
+
+		cookies := res.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != cookieName {
+			t.Fatalf("%s: cookie was not re-issued", name)
+		}
+		if c := cookies[0]; !c.Secure || !c.HttpOnly || c.SameSite != http.SameSiteStrictMode || c.Path != "/" {
+			t.Errorf("%s: incorrect cookie attributes, got %s", name, c.String())
+		}
+
+		reissued, outdated, err := unmarshalToken(cookies[0].Value, ring)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if outdated {
+			t.Errorf("%s: cookie was re-issued with the outdated key", name)
+		}
+		if reissued.userID != userID {
+			t.Errorf("%s: incorrect token userId, got %s, expected %s", name, reissued.userID, userID)
+		}
+	}
+
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: cookieName, Value: currentValue})
+
+	h(res, req)
+	if res.Code != http.StatusOK {
+		t.Fatalf("incorrect response code, got %d", res.Code)
+	}
+	if cookies := res.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("cookie of the active key was re-issued")
+	}
+}
+
+func TestTokenHandler_HeaderCookie_AnotherAudience(t *testing.T) {
+	secretKey := uuid.NewV4().Bytes()
+
+	cookieName := uuid.NewV4().String()
+	cookieValue, err := Marshal(uuid.NewV4().String(), uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Hour), secretKey, WithAudience("service-a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for audience, expectedCode := range map[string]int{"service-a": http.StatusOK, "service-b": http.StatusUnauthorized} {
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: cookieName, Value: cookieValue, HttpOnly: true})
+
+		h, err := TokenHandler(secretKey, cookieName, func(w http.ResponseWriter, r *http.Request) {
+			if _, err := ExtractToken(r.Context()); err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}, WithValidation(WithExpectedAudience(audience)))
+		if err != nil {
+			t.Errorf("could not create nextHandler; details: %s", err.Error())
+		}
+
+		h(res, req)
+		if res.Code != expectedCode {
+			t.Errorf("%s: incorrect response code, got %d, expected %d", audience, res.Code, expectedCode)
+		}
+	}
+}
+
+func TestExtractToken_Reason(t *testing.T) {
+	secretKey := uuid.NewV4().Bytes()
+	cookieName := uuid.NewV4().String()
+	cookieValue, err := Marshal(uuid.NewV4().String(), uuid.NewV4().String(), rand.Uint64(), time.Now().Add(-time.Hour), secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actual error
+	h, err := TokenHandler(secretKey, cookieName, func(w http.ResponseWriter, r *http.Request) {
+		_, actual = ExtractToken(r.Context())
+	})
+	if err != nil {
+		t.Fatalf("could not create nextHandler; details: %s", err.Error())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: cookieName, Value: cookieValue, HttpOnly: true})
+	h(httptest.NewRecorder(), req)
+	if !errors.Is(actual, ErrExpired) {
+		t.Errorf("incorrect error of the expired token, got %v", actual)
+	}
+
+	h(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if !errors.Is(actual, ErrTokenNotFound) {
+		t.Errorf("incorrect error of the request without token, got %v", actual)
+	}
+}
+
+func TestTokenHandler_BearerToken(t *testing.T) {
+	secretKey := uuid.NewV4().Bytes()
+	cookieName := uuid.NewV4().String()
+	cookieUserID := uuid.NewV4().String()
+	bearerUserID := uuid.NewV4().String()
+
+	cookieValue, err := Marshal(cookieUserID, uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Hour), secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bearerValue, err := Marshal(bearerUserID, uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Hour), secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameUserValue, err := Marshal(cookieUserID, uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Hour), secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiredValue, err := Marshal(bearerUserID, uuid.NewV4().String(), rand.Uint64(), time.Now().Add(-time.Hour), secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		cookie     string
+		bearer     string
+		precedence TokenPrecedence
+		userID     string
+		err        error
+	}{
+		{"bearer only", "", bearerValue, PreferCookie, bearerUserID, nil},
+		{"prefer cookie", cookieValue, bearerValue, PreferCookie, cookieUserID, nil},
+		{"prefer bearer", cookieValue, bearerValue, PreferBearer, bearerUserID, nil},
+		{"conflict", cookieValue, bearerValue, RejectConflict, "", ErrTokenConflict},
+		{"same user", cookieValue, sameUserValue, RejectConflict, cookieUserID, nil},
+		{"expired bearer", cookieValue, expiredValue, RejectConflict, cookieUserID, nil},
+		{"expired bearer only", "", expiredValue, PreferCookie, "", ErrExpired},
+		{"malformed bearer", "", "*", PreferBearer, "", ErrMalformed},
+	}
+	for _, tt := range tests {
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if len(tt.cookie) > 0 {
+			req.AddCookie(&http.Cookie{Name: cookieName, Value: tt.cookie, HttpOnly: true})
+		}
+		req.Header.Set(internal.HeaderAuthorization, fmt.Sprintf("%s %s", internal.AuthMethodBearer, tt.bearer))
+
+		var userID, bearer string
+		var tokenErr error
+		h, err := TokenHandler(secretKey, cookieName, func(w http.ResponseWriter, r *http.Request) {
+			bearer, _ = BearerCredential(r.Context())
+			v, err := ExtractToken(r.Context())
+			if err != nil {
+				tokenErr = err
+				return
+			}
+			userID = v.UserID()
+		}, WithBearerToken(), WithTokenPrecedence(tt.precedence))
+		if err != nil {
+			t.Fatalf("could not create nextHandler; details: %s", err.Error())
+		}
+
+		h(res, req)
+		if userID != tt.userID {
+			t.Errorf("%s: incorrect user id, got %s, expected %s", tt.name, userID, tt.userID)
+		}
+		if tt.err != nil && !errors.Is(tokenErr, tt.err) {
+			t.Errorf("%s: incorrect error, got %v, expected %v", tt.name, tokenErr, tt.err)
+		} else if tt.err == nil && tokenErr != nil {
+			t.Errorf("%s: unexpected error, got %v", tt.name, tokenErr)
+		}
+		if bearer != tt.bearer {
+			t.Errorf("%s: incorrect bearer credential, got %s", tt.name, bearer)
+		}
+	}
+}
+
+func TestTokenHandler_BearerToken_SchemeCase(t *testing.T) {
+	secretKey := uuid.NewV4().Bytes()
+	userID := uuid.NewV4().String()
+
+	bearerValue, err := Marshal(userID, uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Hour), secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := TokenHandler(secretKey, "", func(w http.ResponseWriter, r *http.Request) {
+		v, err := ExtractToken(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(v.UserID()))
+	}, WithBearerToken())
+	if err != nil {
+		t.Fatalf("could not create nextHandler; details: %s", err.Error())
+	}
+
+	for _, scheme := range []string{"bearer", "BEARER", "bEaReR"} {
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(internal.HeaderAuthorization, fmt.Sprintf("%s %s", scheme, bearerValue))
+		h(res, req)
+		if s := res.Body.String(); res.Code != http.StatusOK || s != userID {
+			t.Errorf("%s: incorrect response, got %d %s", scheme, res.Code, s)
+		}
+	}
+}
+
+func TestTokenHandler_BearerToken_Disabled(t *testing.T) {
+	secretKey := uuid.NewV4().Bytes()
+	bearerValue, err := Marshal(uuid.NewV4().String(), uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Hour), secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(internal.HeaderAuthorization, fmt.Sprintf("%s %s", internal.AuthMethodBearer, bearerValue))
+
+	var tokenErr error
+	h, err := TokenHandler(secretKey, uuid.NewV4().String(), func(w http.ResponseWriter, r *http.Request) {
+		_, tokenErr = ExtractToken(r.Context())
+	})
+	if err != nil {
+		t.Fatalf("could not create nextHandler; details: %s", err.Error())
+	}
+
+	h(res, req)
+	if !errors.Is(tokenErr, ErrTokenNotFound) {
+		t.Errorf("incorrect error, got %v", tokenErr)
+	}
 }
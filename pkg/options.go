@@ -0,0 +1,202 @@
+package tokeninjector
+
+import (
+	"net/http"
+	"time"
+)
+
+// MarshalOption configures the optional fields of the token created by Marshal or MarshalWithKeyRing.
+type MarshalOption func(*token)
+
+// WithIssuedAt sets the issue time of the token, by default it is the time of Marshal.
+func WithIssuedAt(issuedAt time.Time) MarshalOption {
+	return func(t *token) {
+		t.issuedAt = issuedAt
+	}
+}
+
+// WithNotBefore sets the time before which the token is not valid.
+func WithNotBefore(notBefore time.Time) MarshalOption {
+	return func(t *token) {
+		t.notBefore = notBefore
+	}
+}
+
+// WithIssuer sets the issuer of the token.
+func WithIssuer(issuer string) MarshalOption {
+	return func(t *token) {
+		t.issuer = issuer
+	}
+}
+
+// WithAudience sets the audience of the token, i.e. the service the token is intended for.
+func WithAudience(audience string) MarshalOption {
+	return func(t *token) {
+		t.audience = audience
+	}
+}
+
+// WithTokenID sets the unique id of the token, by default it is a random UUID.
+func WithTokenID(tokenID string) MarshalOption {
+	return func(t *token) {
+		t.tokenID = tokenID
+	}
+}
+
+// WithScopes sets the scopes granted to the token, e.g. "orders:read" or "orders:*", see MatchScope.
+// A scope must not be empty or contain spaces, quotes or backslashes.
+func WithScopes(scopes ...string) MarshalOption {
+	return func(t *token) {
+		t.scopes = append(t.scopes, scopes...)
+	}
+}
+
+// WithGeneration sets the generation of the user sessions, i.e. the current generation of the user
+// in the GenerationStore, the token is rejected once the generation is incremented.
+func WithGeneration(generation uint64) MarshalOption {
+	return func(t *token) {
+		t.generation = generation
+	}
+}
+
+// HandlerOption configures the middleware created by TokenHandler or KeyRingHandler.
+type HandlerOption func(*handlerOptions)
+
+// handlerOptions is a structure that contains the optional settings of the middleware.
+type handlerOptions struct {
+	reissueCookie *http.Cookie
+	cookie        *CookieOptions
+	validation    []ValidateOption
+	jwtBearer     bool
+	jwtAlgorithm  string
+	jwtKey        any
+	bearerToken   bool
+	precedence    TokenPrecedence
+	requireToken  bool
+	responder     ErrorResponder
+	basicVerifier CredentialVerifier
+	sliding       *slidingExpiration
+}
+
+// TokenPrecedence defines which token the middleware accepts if both the cookie and the bearer credential
+// carry a valid token.
+type TokenPrecedence uint8
+
+const (
+	// PreferCookie accepts the token of the cookie, it is the default.
+	PreferCookie TokenPrecedence = iota
+	// PreferBearer accepts the token of the bearer credential.
+	PreferBearer
+	// RejectConflict rejects both tokens with ErrTokenConflict if they belong to different users,
+	// otherwise accepts the token of the cookie.
+	RejectConflict
+)
+
+// newHandlerOptions applies the options to the default settings.
+func newHandlerOptions(opts []HandlerOption) *handlerOptions {
+	o := &handlerOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithCookieReissue makes the middleware re-issue a valid cookie that was encrypted with a non-active key
+// or in the v1 format. The new cookie carries the same claims encrypted with the active key in the v2 format.
+// The attributes (path, domain, secure, same site) are copied from the template unless WithCookieOptions is set,
+// the name is the cookie name of the middleware and the expiration is the expiration of the token.
+// The cookie is always HttpOnly, like the cookie of IssueCookie.
+func WithCookieReissue(template http.Cookie) HandlerOption {
+	return func(o *handlerOptions) {
+		o.reissueCookie = &template
+	}
+}
+
+// WithCookieOptions sets the attributes of the cookies re-issued and renewed by the middleware
+// (see WithCookieReissue and WithSlidingExpiration), they should be the options of IssueCookie,
+// so the browser replaces the cookie instead of keeping two of them. The name is the cookie name of the middleware,
+// the keys and the session store are not used.
+func WithCookieOptions(opts CookieOptions) HandlerOption {
+	return func(o *handlerOptions) {
+		o.cookie = &opts
+	}
+}
+
+// WithValidation sets the checks of the token accepted by the middleware, e.g. the expected audience.
+func WithValidation(opts ...ValidateOption) HandlerOption {
+	return func(o *handlerOptions) {
+		o.validation = append(o.validation, opts...)
+	}
+}
+
+// WithJWTBearer makes the middleware accept a JWT from the "Authorization: Bearer" header as an alternative
+// to the cookie, the JWT is verified like UnmarshalJWT with the algorithm and key.
+//   - key: the secret key ([]byte) for HS algorithms or the public key (ed25519.PublicKey) for EdDSA.
+func WithJWTBearer(alg string, key any) HandlerOption {
+	return func(o *handlerOptions) {
+		o.jwtBearer = true
+		o.jwtAlgorithm = alg
+		o.jwtKey = key
+	}
+}
+
+// WithBearerToken makes the middleware accept a token created by Marshal from the "Authorization: Bearer" header
+// as an alternative to the cookie, the token is decoded and validated like the cookie.
+func WithBearerToken() HandlerOption {
+	return func(o *handlerOptions) {
+		o.bearerToken = true
+	}
+}
+
+// WithTokenPrecedence sets which token is accepted if both the cookie and the bearer credential carry a token.
+func WithTokenPrecedence(precedence TokenPrecedence) HandlerOption {
+	return func(o *handlerOptions) {
+		o.precedence = precedence
+	}
+}
+
+// WithRequireToken makes the middleware reject the request with 401 and the WWW-Authenticate challenge
+// if it has no valid token, the next handler is called only with a valid token.
+func WithRequireToken() HandlerOption {
+	return func(o *handlerOptions) {
+		o.requireToken = true
+	}
+}
+
+// WithErrorResponder sets the responder of the rejected requests, by default it is DefaultErrorResponder.
+// The responder is also used by RequireToken and the other middlewares placed after this one.
+func WithErrorResponder(responder ErrorResponder) HandlerOption {
+	return func(o *handlerOptions) {
+		o.responder = responder
+	}
+}
+
+// WithBasicVerifier makes the middleware verify the username and password of the "Authorization: Basic" header
+// with the verifier, the token of the verified user is accepted like the bearer token, see WithTokenPrecedence.
+func WithBasicVerifier(verifier CredentialVerifier) HandlerOption {
+	return func(o *handlerOptions) {
+		o.basicVerifier = verifier
+	}
+}
+
+// WithSlidingExpiration makes the middleware renew the cookie token that expires within the window:
+// the token is re-issued with the expiration in the lifetime from now, but not later than the maximum lifetime
+// from its issue time, so the session can not be extended forever. The issue time and token id are kept.
+// The cookie attributes are set by WithCookieOptions (or WithCookieReissue), the secure defaults are used without them.
+// The tokens without the issue time (e.g. v1) are not renewed. RevokeToken revokes the token of the context
+// until the end of the maximum lifetime, so the renewed copies of the revoked token are rejected too.
+func WithSlidingExpiration(window time.Duration, lifetime time.Duration, maxLifetime time.Duration) HandlerOption {
+	return func(o *handlerOptions) {
+		o.sliding = &slidingExpiration{window: window, lifetime: lifetime, maxLifetime: maxLifetime}
+	}
+}
+
+// WithRevocationStore makes the middleware reject the tokens revoked in the store, see WithRevocationCheck.
+func WithRevocationStore(store RevocationStore) HandlerOption {
+	return WithValidation(WithRevocationCheck(store))
+}
+
+// WithGenerationStore makes the middleware reject the tokens of the outdated generations, see WithGenerationCheck.
+func WithGenerationStore(store GenerationStore) HandlerOption {
+	return WithValidation(WithGenerationCheck(store))
+}
@@ -0,0 +1,96 @@
+package tokeninjector
+
+import (
+	"fmt"
+	"math/bits"
+	"sync"
+)
+
+// Permission is the set of up to 64 capabilities encoded in the role id of the token, one bit per capability.
+type Permission uint64
+
+// PermissionOf returns the permissions encoded in the role id of the token.
+func PermissionOf(t Token) Permission {
+	return Permission(t.UserRoleID())
+}
+
+// HasAll reports whether the permissions include all the required ones.
+func (p Permission) HasAll(required Permission) bool {
+	return p&required == required
+}
+
+// HasAny reports whether the permissions include at least one of the required ones,
+// an empty set of the required permissions is never matched.
+func (p Permission) HasAny(required Permission) bool {
+	return p&required != 0
+}
+
+// PermissionRegistry maps the named permissions to the bits of Permission.
+// The bits are set explicitly, so they are stable among the services that share the tokens.
+type PermissionRegistry struct {
+	mutex sync.RWMutex
+	bits  map[string]Permission
+	names [64]string
+}
+
+// NewPermissionRegistry creates an empty permission registry.
+func NewPermissionRegistry() *PermissionRegistry {
+	return &PermissionRegistry{bits: make(map[string]Permission)}
+}
+
+// Register maps the name to the bit (0-63) and returns the permission of the bit.
+func (r *PermissionRegistry) Register(name string, bit uint) (Permission, error) {
+	if len(name) == 0 {
+		return 0, fmt.Errorf("permission name is empty")
+	}
+	if bit >= 64 {
+		return 0, fmt.Errorf("permission bit %d is out of range, maximum 63", bit)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.bits[name]; ok {
+		return 0, fmt.Errorf("permission %q is already registered", name)
+	}
+	if n := r.names[bit]; len(n) > 0 {
+		return 0, fmt.Errorf("permission bit %d is already registered as %q", bit, n)
+	}
+	p := Permission(1) << bit
+	r.bits[name] = p
+	r.names[bit] = name
+
+	return p, nil
+}
+
+// Lookup returns the permissions of the names, unknown names are rejected with an error.
+func (r *PermissionRegistry) Lookup(names ...string) (Permission, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	var p Permission
+	for _, name := range names {
+		b, ok := r.bits[name]
+		if !ok {
+			return 0, fmt.Errorf("permission %q is not registered", name)
+		}
+		p |= b
+	}
+
+	return p, nil
+}
+
+// Names returns the names of the permissions in the order of their bits, unregistered bits are skipped.
+func (r *PermissionRegistry) Names(p Permission) []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	names := make([]string, 0, bits.OnesCount64(uint64(p)))
+	for ; p != 0; p &= p - 1 {
+		if n := r.names[bits.TrailingZeros64(uint64(p))]; len(n) > 0 {
+			names = append(names, n)
+		}
+	}
+
+	return names
+}
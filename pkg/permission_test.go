@@ -0,0 +1,68 @@
+package tokeninjector
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPermission(t *testing.T) {
+	p := Permission(0b0110)
+
+	if !p.HasAll(0b0010) || !p.HasAll(0b0110) || !p.HasAll(0) {
+		t.Errorf("incorrect has all, got false")
+	}
+	if p.HasAll(0b0011) {
+		t.Errorf("incorrect has all, got true")
+	}
+	if !p.HasAny(0b0011) {
+		t.Errorf("incorrect has any, got false")
+	}
+	if p.HasAny(0b1001) || p.HasAny(0) {
+		t.Errorf("incorrect has any, got true")
+	}
+}
+
+func TestPermissionRegistry(t *testing.T) {
+	r := NewPermissionRegistry()
+
+	read, err := r.Register("read", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write, err := r.Register("write", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := r.Register("admin", 63)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read != 1 || write != 2 || admin != 1<<63 {
+		t.Errorf("incorrect permission bits, got %#x %#x %#x", read, write, admin)
+	}
+
+	if _, err = r.Register("read", 5); err == nil {
+		t.Errorf("incorrect register, the duplicated name is accepted")
+	}
+	if _, err = r.Register("delete", 1); err == nil {
+		t.Errorf("incorrect register, the duplicated bit is accepted")
+	}
+	if _, err = r.Register("delete", 64); err == nil {
+		t.Errorf("incorrect register, the bit out of range is accepted")
+	}
+
+	p, err := r.Lookup("read", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != read|admin {
+		t.Errorf("incorrect lookup, got %#x", p)
+	}
+	if _, err = r.Lookup("read", "delete"); err == nil {
+		t.Errorf("incorrect lookup, the unknown name is accepted")
+	}
+
+	if names := r.Names(p | 1<<10); !slices.Equal(names, []string{"read", "admin"}) {
+		t.Errorf("incorrect names, got %v", names)
+	}
+}
@@ -0,0 +1,349 @@
+package tokeninjector
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/twinj/uuid"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// TokenPair is the access token and the refresh token issued by RefreshIssuer,
+// it is serialized like the response of the OAuth 2.0 token endpoint.
+type TokenPair struct {
+	AccessToken      string    `json:"access_token"`
+	TokenType        string    `json:"token_type"`
+	ExpiresIn        int64     `json:"expires_in"`
+	RefreshToken     string    `json:"refresh_token"`
+	AccessExpiredAt  time.Time `json:"-"`
+	RefreshExpiredAt time.Time `json:"-"`
+}
+
+// RefreshRecord is the stored state of the refresh token. The refresh token itself is not stored, only its hash.
+// The refresh tokens issued one from another belong to the same family.
+type RefreshRecord struct {
+	// Hash is the hex SHA-256 hash of the refresh token.
+	Hash string
+	// FamilyID is the id of the family, it is the same for all rotations of the refresh token.
+	FamilyID string
+	// FamilyIssuedAt is the time the first refresh token of the family was issued.
+	FamilyIssuedAt time.Time
+	// ExpiredAt is the expiration time of the refresh token.
+	ExpiredAt time.Time
+	// Payload is the opaque payload of the access token, its claims are copied to the refreshed access tokens.
+	Payload []byte
+	// Used reports that the refresh token was exchanged.
+	Used bool
+	// Revoked reports that the family of the refresh token was revoked.
+	Revoked bool
+}
+
+// RefreshStore keeps the records of the refresh tokens.
+type RefreshStore interface {
+	// Save stores the record of the new refresh token.
+	Save(record RefreshRecord) error
+	// Consume marks the record with the hash as used and returns the record as it was before, so the repeated use
+	// is reported by the Used flag. The expired record is returned without being marked, so it stays expired
+	// rather than reused. It must be atomic. The unknown hash is reported with ErrRefreshInvalid.
+	Consume(hash string) (RefreshRecord, error)
+	// RevokeFamily marks all records of the family as revoked.
+	RevokeFamily(familyID string) error
+}
+
+// RefreshIssuer issues the pairs of the access token (encrypted with the key ring like MarshalWithKeyRing)
+// and the opaque refresh token. The refresh token is rotated on every exchange, the reuse of an exchanged
+// refresh token revokes its whole family (OAuth 2.0 Security Best Current Practice, section 4.14).
+type RefreshIssuer struct {
+	ring            *KeyRing
+	store           RefreshStore
+	accessLifetime  time.Duration
+	refreshLifetime time.Duration
+	maxLifetime     time.Duration
+	validation      *validateOptions
+}
+
+// NewRefreshIssuer creates the issuer of the token pairs.
+//   - accessLifetime: the lifetime of the access tokens.
+//   - refreshLifetime: the lifetime of each refresh token.
+//   - maxLifetime: the lifetime of the refresh token family, the rotations do not extend it, zero means no limit.
+//   - opts: the checks of the last access token of the family on refresh (e.g. WithRevocationCheck,
+//     WithGenerationCheck), the expiration is not checked. The rejected family is revoked.
+func NewRefreshIssuer(ring *KeyRing, store RefreshStore, accessLifetime time.Duration, refreshLifetime time.Duration, maxLifetime time.Duration, opts ...ValidateOption) (*RefreshIssuer, error) {
+	switch {
+	case ring == nil:
+		return nil, errors.New("key ring is not defined")
+	case store == nil:
+		return nil, errors.New("refresh store is not defined")
+	case accessLifetime <= 0 || refreshLifetime <= 0:
+		return nil, errors.New("access and refresh lifetimes must be positive")
+	case maxLifetime < 0:
+		return nil, errors.New("maximum lifetime must not be negative")
+	}
+	validation := newValidateOptions(opts)
+	validation.skipExpiry = true
+	return &RefreshIssuer{
+		ring:            ring,
+		store:           store,
+		accessLifetime:  accessLifetime,
+		refreshLifetime: refreshLifetime,
+		maxLifetime:     maxLifetime,
+		validation:      validation,
+	}, nil
+}
+
+// IssuePair issues the access token with the claims and the refresh token of a new family, e.g. on login.
+// The options set the claims of the access token, they are kept on refresh.
+func (i *RefreshIssuer) IssuePair(userID string, userName string, roleID uint64, opts ...MarshalOption) (TokenPair, error) {
+	now := time.Now()
+	t := newToken(userID, userName, roleID, now.Add(i.accessLifetime), opts)
+	return i.issue(t, uuid.NewV4().String(), now, now)
+}
+
+// Refresh exchanges the refresh token for a new pair, the refresh token can be exchanged only once.
+// The returned error is a TokenError: ErrRefreshInvalid, ErrRefreshReused, ErrExpired
+// or ErrUnavailable if the store failed. If the last access token of the family is rejected by the checks
+// of NewRefreshIssuer (e.g. it is revoked), the family is revoked and ErrRefreshInvalid is returned.
+func (i *RefreshIssuer) Refresh(refreshToken string) (TokenPair, error) {
+	record, err := i.store.Consume(hashRefreshToken(refreshToken))
+	if errors.Is(err, ErrRefreshInvalid) {
+		return TokenPair{}, asTokenError(ErrRefreshInvalid, err)
+	}
+	if err != nil {
+		return TokenPair{}, newTokenError(ErrUnavailable, "could not consume the refresh token; details: %s", err.Error())
+	}
+
+	now := time.Now()
+	switch {
+	case record.Revoked:
+		return TokenPair{}, newTokenError(ErrRefreshInvalid, "family %s is revoked", record.FamilyID)
+	case record.Used:
+		if err = i.store.RevokeFamily(record.FamilyID); err != nil {
+			return TokenPair{}, newTokenError(ErrUnavailable, "could not revoke family %s; details: %s", record.FamilyID, err.Error())
+		}
+		return TokenPair{}, newTokenError(ErrRefreshReused, "family %s is revoked", record.FamilyID)
+	case !now.Before(record.ExpiredAt):
+		return TokenPair{}, newTokenError(ErrExpired, "refresh token expired at %s", record.ExpiredAt)
+	}
+
+	t, err := convertFromByte(record.Payload)
+	if err != nil {
+		return TokenPair{}, asTokenError(ErrMalformed, err)
+	}
+	if err = validateToken(t, i.validation); errors.Is(err, ErrUnavailable) {
+		return TokenPair{}, err
+	} else if err != nil {
+		if revokeErr := i.store.RevokeFamily(record.FamilyID); revokeErr != nil {
+			return TokenPair{}, newTokenError(ErrUnavailable, "could not revoke family %s; details: %s", record.FamilyID, revokeErr.Error())
+		}
+		return TokenPair{}, newTokenError(ErrRefreshInvalid, "family %s is revoked; details: %s", record.FamilyID, err.Error())
+	}
+	t.issuedAt = now
+	t.notBefore = time.Time{}
+	t.expiredAt = now.Add(i.accessLifetime)
+	t.tokenID = uuid.NewV4().String()
+
+	return i.issue(t, record.FamilyID, record.FamilyIssuedAt, now)
+}
+
+// issue encodes the access token and saves the new refresh token of the family.
+func (i *RefreshIssuer) issue(t *token, familyID string, familyIssuedAt time.Time, now time.Time) (TokenPair, error) {
+	refreshExpiredAt := now.Add(i.refreshLifetime)
+	if i.maxLifetime > 0 {
+		if limit := familyIssuedAt.Add(i.maxLifetime); refreshExpiredAt.After(limit) {
+			refreshExpiredAt = limit
+		}
+	}
+	if !now.Before(refreshExpiredAt) {
+		return TokenPair{}, newTokenError(ErrExpired, "family %s reached the maximum lifetime", familyID)
+	}
+
+	payload, err := convertToByte(t)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	accessToken, err := marshalToken(t, i.ring)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	refreshToken, err := newOpaqueID()
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	err = i.store.Save(RefreshRecord{
+		Hash:           hashRefreshToken(refreshToken),
+		FamilyID:       familyID,
+		FamilyIssuedAt: familyIssuedAt,
+		ExpiredAt:      refreshExpiredAt,
+		Payload:        payload,
+	})
+	if err != nil {
+		return TokenPair{}, newTokenError(ErrUnavailable, "could not save the refresh token; details: %s", err.Error())
+	}
+
+	return TokenPair{
+		AccessToken:      accessToken,
+		TokenType:        "Bearer",
+		ExpiresIn:        int64(t.expiredAt.Sub(now).Seconds()),
+		RefreshToken:     refreshToken,
+		AccessExpiredAt:  t.expiredAt,
+		RefreshExpiredAt: refreshExpiredAt,
+	}, nil
+}
+
+// hashRefreshToken returns the hash of the refresh token, which is the key of its record.
+func hashRefreshToken(refreshToken string) string {
+	h := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(h[:])
+}
+
+// RefreshHandler is the handler of the token endpoint that exchanges the refresh token for a new pair.
+// The refresh token is read from the "refresh_token" field of the POST form or JSON body, the new pair is written
+// as JSON. The rejections are written by the responder of WithErrorResponder with 400 and "invalid_grant",
+// the failures of the store with 503. Only the option WithErrorResponder applies, the others are rejected with an error.
+func RefreshHandler(issuer *RefreshIssuer, opts ...HandlerOption) (http.HandlerFunc, error) {
+	if issuer == nil {
+		return nil, errors.New("refresh issuer is not defined")
+	}
+	options := newHandlerOptions(opts)
+	switch {
+	case options.reissueCookie != nil || options.cookie != nil || options.sliding != nil,
+		options.jwtBearer || options.bearerToken || options.basicVerifier != nil || options.precedence != PreferCookie,
+		options.requireToken || len(options.validation) > 0:
+		return nil, errors.New("refresh handler supports only the error responder option")
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if options.responder != nil {
+			r = r.WithContext(contextWithResponder(r.Context(), options.responder))
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			respondError(w, r, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+			return
+		}
+
+		var refreshToken string
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+			var body struct {
+				RefreshToken string `json:"refresh_token"`
+			}
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&body); err != nil {
+				respondError(w, r, http.StatusBadRequest, asTokenError(ErrMalformed, err))
+				return
+			}
+			refreshToken = body.RefreshToken
+		} else {
+			refreshToken = r.PostFormValue("refresh_token")
+		}
+		if len(refreshToken) == 0 {
+			respondError(w, r, http.StatusBadRequest, ErrTokenNotFound)
+			return
+		}
+
+		pair, err := issuer.Refresh(refreshToken)
+		if errors.Is(err, ErrUnavailable) {
+			respondError(w, r, http.StatusServiceUnavailable, err)
+			return
+		}
+		if err != nil {
+			respondError(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(pair)
+	}, nil
+}
+
+// MemoryRefreshStore is the RefreshStore in memory, the records are removed after the refresh tokens expire.
+type MemoryRefreshStore struct {
+	mutex    sync.Mutex
+	records  map[string]*RefreshRecord
+	families map[string][]string
+	swept    time.Time
+}
+
+// NewMemoryRefreshStore creates an empty MemoryRefreshStore.
+func NewMemoryRefreshStore() *MemoryRefreshStore {
+	return &MemoryRefreshStore{
+		records:  make(map[string]*RefreshRecord),
+		families: make(map[string][]string),
+		swept:    time.Now(),
+	}
+}
+
+// Save stores the record of the new refresh token.
+func (s *MemoryRefreshStore) Save(record RefreshRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if now := time.Now(); now.Sub(s.swept) >= sweepInterval {
+		s.sweep(now)
+	}
+	if _, ok := s.records[record.Hash]; ok {
+		return fmt.Errorf("refresh token %s is already stored", record.Hash)
+	}
+	s.records[record.Hash] = &record
+	s.families[record.FamilyID] = append(s.families[record.FamilyID], record.Hash)
+
+	return nil
+}
+
+// Consume marks the record as used and returns the record as it was before, the expired record is not marked.
+func (s *MemoryRefreshStore) Consume(hash string) (RefreshRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	record, ok := s.records[hash]
+	if !ok {
+		return RefreshRecord{}, newTokenError(ErrRefreshInvalid, "refresh token is unknown")
+	}
+	previous := *record
+	if time.Now().Before(record.ExpiredAt) {
+		record.Used = true
+	}
+
+	return previous, nil
+}
+
+// RevokeFamily marks all records of the family as revoked.
+func (s *MemoryRefreshStore) RevokeFamily(familyID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, hash := range s.families[familyID] {
+		if record, ok := s.records[hash]; ok {
+			record.Revoked = true
+		}
+	}
+
+	return nil
+}
+
+// sweep removes the records of the expired refresh tokens, the caller holds the lock.
+func (s *MemoryRefreshStore) sweep(now time.Time) {
+	for familyID, hashes := range s.families {
+		kept := hashes[:0]
+		for _, hash := range hashes {
+			if record, ok := s.records[hash]; ok && now.Before(record.ExpiredAt) {
+				kept = append(kept, hash)
+			} else {
+				delete(s.records, hash)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.families, familyID)
+		} else {
+			s.families[familyID] = kept
+		}
+	}
+	s.swept = now
+}
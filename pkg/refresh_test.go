@@ -0,0 +1,303 @@
+package tokeninjector
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/twinj/uuid"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRefreshIssuer(t *testing.T) {
+	ring, err := newSingleKeyRing(uuid.NewV4().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := NewRefreshIssuer(ring, NewMemoryRefreshStore(), time.Minute, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID := uuid.NewV4().String()
+	roleID := rand.Uint64()
+	scopes := []string{"orders:read"}
+
+	pair, err := issuer.IssuePair(userID, uuid.NewV4().String(), roleID, WithScopes(scopes...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pair.AccessToken) == 0 || len(pair.RefreshToken) == 0 || pair.TokenType != "Bearer" || pair.ExpiresIn != 60 {
+		t.Fatalf("incorrect token pair, got %+v", pair)
+	}
+
+	refreshed, err := issuer.Refresh(pair.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshed.RefreshToken == pair.RefreshToken || refreshed.AccessToken == pair.AccessToken {
+		t.Errorf("incorrect rotation, the tokens are not changed")
+	}
+	tkn, err := UnmarshalToken(refreshed.AccessToken, ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tkn.UserID() != userID || tkn.UserRoleID() != roleID || !slices.Equal(tkn.Scopes(), scopes) {
+		t.Errorf("incorrect refreshed token, got %s %d %v", tkn.UserID(), tkn.UserRoleID(), tkn.Scopes())
+	}
+
+	// the reuse of the exchanged refresh token revokes the family
+	if _, err = issuer.Refresh(pair.RefreshToken); !errors.Is(err, ErrRefreshReused) {
+		t.Errorf("incorrect reuse, got %v", err)
+	}
+	if _, err = issuer.Refresh(refreshed.RefreshToken); !errors.Is(err, ErrRefreshInvalid) {
+		t.Errorf("incorrect refresh of the revoked family, got %v", err)
+	}
+	if _, err = issuer.Refresh(uuid.NewV4().String()); !errors.Is(err, ErrRefreshInvalid) {
+		t.Errorf("incorrect refresh of the unknown token, got %v", err)
+	}
+
+	// the family does not outlive the maximum lifetime
+	issuer, err = NewRefreshIssuer(ring, NewMemoryRefreshStore(), time.Minute, time.Hour, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pair, err = issuer.IssuePair(userID, "", roleID); err != nil {
+		t.Fatal(err)
+	}
+	if until := time.Until(pair.RefreshExpiredAt); until > time.Second {
+		t.Errorf("incorrect refresh expiration, got %s", until)
+	}
+	time.Sleep(time.Second)
+	for i := 0; i < 2; i++ {
+		if _, err = issuer.Refresh(pair.RefreshToken); !errors.Is(err, ErrExpired) {
+			t.Errorf("incorrect refresh %d of the expired token, got %v", i, err)
+		}
+	}
+
+	if _, err = NewRefreshIssuer(ring, nil, time.Minute, time.Hour, 0); err == nil {
+		t.Errorf("issuer without store was created")
+	}
+}
+
+func TestRefreshIssuer_Validation(t *testing.T) {
+	ring, err := newSingleKeyRing(uuid.NewV4().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gens := NewMemoryGenerationStore()
+	revoked := NewMemoryRevocationStore()
+	issuer, err := NewRefreshIssuer(ring, NewMemoryRefreshStore(), time.Minute, time.Hour, 0, WithGenerationCheck(gens), WithRevocationCheck(revoked))
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID := uuid.NewV4().String()
+
+	// the revoked access token of the family
+	pair, err := issuer.IssuePair(userID, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tkn, err := UnmarshalToken(pair.AccessToken, ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = RevokeToken(revoked, tkn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = issuer.Refresh(pair.RefreshToken); !errors.Is(err, ErrRefreshInvalid) {
+		t.Errorf("incorrect refresh of the revoked token, got %v", err)
+	}
+
+	// the family of the user of the older generation
+	if pair, err = issuer.IssuePair(userID, "", 0); err != nil {
+		t.Fatal(err)
+	}
+	if refreshed, err := issuer.Refresh(pair.RefreshToken); err != nil {
+		t.Errorf("incorrect refresh of the valid token, got %v", err)
+	} else {
+		pair = refreshed
+	}
+	if _, err = gens.Increment(userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = issuer.Refresh(pair.RefreshToken); !errors.Is(err, ErrRefreshInvalid) {
+		t.Errorf("incorrect refresh of the older generation, got %v", err)
+	}
+	if _, err = issuer.Refresh(pair.RefreshToken); !errors.Is(err, ErrRefreshInvalid) || errors.Is(err, ErrRefreshReused) {
+		t.Errorf("incorrect refresh of the revoked family, got %v", err)
+	}
+}
+
+func TestMemoryRefreshStore(t *testing.T) {
+	s := NewMemoryRefreshStore()
+	familyID := uuid.NewV4().String()
+	active := RefreshRecord{Hash: uuid.NewV4().String(), FamilyID: familyID, ExpiredAt: time.Now().Add(time.Hour)}
+	expired := RefreshRecord{Hash: uuid.NewV4().String(), FamilyID: familyID, ExpiredAt: time.Now().Add(-time.Second)}
+
+	for _, r := range []RefreshRecord{active, expired} {
+		if err := s.Save(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Save(active); err == nil {
+		t.Errorf("duplicate record was saved")
+	}
+
+	if r, err := s.Consume(active.Hash); err != nil || r.Used {
+		t.Errorf("incorrect first use, got %+v, %v", r, err)
+	}
+	if r, err := s.Consume(active.Hash); err != nil || !r.Used {
+		t.Errorf("incorrect second use, got %+v, %v", r, err)
+	}
+	for i := 0; i < 2; i++ {
+		if r, err := s.Consume(expired.Hash); err != nil || r.Used {
+			t.Errorf("incorrect use %d of the expired record, got %+v, %v", i, r, err)
+		}
+	}
+	if _, err := s.Consume(uuid.NewV4().String()); !errors.Is(err, ErrRefreshInvalid) {
+		t.Errorf("incorrect use of the unknown record, got %v", err)
+	}
+
+	if err := s.RevokeFamily(familyID); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := s.Consume(active.Hash); err != nil || !r.Revoked {
+		t.Errorf("incorrect use of the revoked record, got %+v, %v", r, err)
+	}
+
+	// the expired records are evicted by the sweep
+	s.swept = time.Time{}
+	if err := s.Save(RefreshRecord{Hash: uuid.NewV4().String(), FamilyID: uuid.NewV4().String(), ExpiredAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.records[expired.Hash]; ok {
+		t.Errorf("incorrect sweep, the expired record is kept")
+	}
+	if len(s.records) != 2 || len(s.families) != 2 {
+		t.Errorf("incorrect number of records, got %d, %d", len(s.records), len(s.families))
+	}
+}
+
+func TestRefreshHandler(t *testing.T) {
+	ring, err := newSingleKeyRing(uuid.NewV4().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := NewRefreshIssuer(ring, NewMemoryRefreshStore(), time.Minute, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := RefreshHandler(issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err := issuer.IssuePair(uuid.NewV4().String(), uuid.NewV4().String(), rand.Uint64())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {pair.RefreshToken}}
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	h(res, req)
+	if res.Code != http.StatusOK {
+		t.Fatalf("incorrect response code, got %d", res.Code)
+	}
+	if s := res.Header().Get("Cache-Control"); s != "no-store" {
+		t.Errorf("incorrect cache control, got %s", s)
+	}
+	var refreshed TokenPair
+	if err = json.Unmarshal(res.Body.Bytes(), &refreshed); err != nil {
+		t.Fatal(err)
+	}
+	if len(refreshed.AccessToken) == 0 || len(refreshed.RefreshToken) == 0 {
+		t.Fatalf("incorrect response body, got %s", res.Body.String())
+	}
+
+	res = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(`{"refresh_token":"`+refreshed.RefreshToken+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	h(res, req)
+	if res.Code != http.StatusOK {
+		t.Errorf("incorrect response code of the JSON body, got %d", res.Code)
+	}
+
+	res = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	h(res, req)
+	if res.Code != http.StatusBadRequest || !strings.Contains(res.Body.String(), "invalid_grant") {
+		t.Errorf("incorrect response of the reused token, got %d %s", res.Code, res.Body.String())
+	}
+
+	res = httptest.NewRecorder()
+	h(res, httptest.NewRequest(http.MethodPost, "/token", nil))
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("incorrect response code without token, got %d", res.Code)
+	}
+
+	res = httptest.NewRecorder()
+	h(res, httptest.NewRequest(http.MethodGet, "/token", nil))
+	if res.Code != http.StatusMethodNotAllowed {
+		t.Errorf("incorrect response code of GET, got %d", res.Code)
+	}
+}
+
+func TestRefreshHandler_StoreFailure(t *testing.T) {
+	ring, err := newSingleKeyRing(uuid.NewV4().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &failingRefreshStore{MemoryRefreshStore: NewMemoryRefreshStore(), err: errors.New("could not write /var/lib/secret/refresh")}
+	issuer, err := NewRefreshIssuer(ring, store, time.Minute, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := RefreshHandler(issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err := issuer.IssuePair(uuid.NewV4().String(), uuid.NewV4().String(), rand.Uint64())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = issuer.Refresh(pair.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+
+	// the reuse fails to revoke the family
+	if _, err = issuer.Refresh(pair.RefreshToken); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("incorrect error of the failed store, got %v", err)
+	}
+
+	form := url.Values{"refresh_token": {pair.RefreshToken}}
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	h(res, req)
+	if s := res.Body.String(); res.Code != http.StatusServiceUnavailable || strings.Contains(s, "/var/lib") || !strings.Contains(s, "temporarily_unavailable") {
+		t.Errorf("incorrect response of the failed store, got %d %s", res.Code, s)
+	}
+
+	for _, opt := range []HandlerOption{WithRequireToken(), WithBearerToken(), WithRevocationStore(NewMemoryRevocationStore())} {
+		if _, err = RefreshHandler(issuer, opt); err == nil {
+			t.Errorf("unsupported option was accepted")
+		}
+	}
+}
+
+// failingRefreshStore is the MemoryRefreshStore that fails to revoke the families.
+type failingRefreshStore struct {
+	*MemoryRefreshStore
+	err error
+}
+
+func (s *failingRefreshStore) RevokeFamily(string) error { return s.err }
@@ -0,0 +1,110 @@
+package tokeninjector
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+)
+
+// RequireToken is a middleware that rejects the request with 401 if it has no valid token,
+// it is placed after TokenHandler, so the handlers of the protected routes do not check the token themselves.
+// The response is written by the ErrorResponder of TokenHandler, see WithErrorResponder.
+// If the token could not be checked (ErrUnavailable, e.g. the revocation store is down), the status is 503.
+func RequireToken(nextFunc http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, err := ExtractToken(r.Context()); err != nil {
+			respondTokenError(w, r, err)
+			return
+		}
+		nextFunc(w, r)
+	}
+}
+
+// respondTokenError rejects the request without a valid token with 401,
+// or with 503 if the token could not be checked.
+func respondTokenError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ErrUnavailable) {
+		respondError(w, r, http.StatusServiceUnavailable, err)
+		return
+	}
+	respondError(w, r, http.StatusUnauthorized, err)
+}
+
+// RequireRole returns a middleware that rejects the request with 401 if it has no valid token
+// and with 403 if the role of the token is not one of the roles, see RoleHierarchy for the inherited roles.
+// Like RequireToken, it is placed after TokenHandler and can guard a single route of http.ServeMux.
+func RequireRole(roles ...uint64) func(http.HandlerFunc) http.HandlerFunc {
+	return requireRole(roles, func(roleID uint64, requiredRoleID uint64) bool { return roleID == requiredRoleID })
+}
+
+// requireRole creates the middleware of RequireRole with the function that matches the token role.
+func requireRole(roles []uint64, includes func(roleID uint64, requiredRoleID uint64) bool) func(http.HandlerFunc) http.HandlerFunc {
+	roles = slices.Clone(roles)
+	return func(nextFunc http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			t, err := ExtractToken(r.Context())
+			if err != nil {
+				respondTokenError(w, r, err)
+				return
+			}
+			roleID := t.UserRoleID()
+			for _, requiredRoleID := range roles {
+				if includes(roleID, requiredRoleID) {
+					nextFunc(w, r)
+					return
+				}
+			}
+			respondError(w, r, http.StatusForbidden, newTokenError(ErrRoleNotAllowed, "role %d", roleID))
+		}
+	}
+}
+
+// RequirePermission returns a middleware that rejects the request with 401 if it has no valid token
+// and with 403 if the permissions encoded in the role id of the token do not include all the required ones.
+func RequirePermission(required Permission) func(http.HandlerFunc) http.HandlerFunc {
+	return requirePermission(required, Permission.HasAll)
+}
+
+// RequireAnyPermission is like RequirePermission, but one of the required permissions is enough.
+func RequireAnyPermission(required Permission) func(http.HandlerFunc) http.HandlerFunc {
+	return requirePermission(required, Permission.HasAny)
+}
+
+// requirePermission creates the middleware of RequirePermission with the function that matches the permissions.
+func requirePermission(required Permission, has func(p Permission, required Permission) bool) func(http.HandlerFunc) http.HandlerFunc {
+	return func(nextFunc http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			t, err := ExtractToken(r.Context())
+			if err != nil {
+				respondTokenError(w, r, err)
+				return
+			}
+			if p := PermissionOf(t); !has(p, required) {
+				respondError(w, r, http.StatusForbidden, newTokenError(ErrPermissionDenied, "permissions %#x, required %#x", uint64(p), uint64(required)))
+				return
+			}
+			nextFunc(w, r)
+		}
+	}
+}
+
+// RequireScope returns a middleware that rejects the request with 401 if it has no valid token
+// and with 403 (insufficient_scope) if the scopes of the token do not include all the required scopes,
+// the required scopes are sent in the WWW-Authenticate challenge.
+func RequireScope(scopes ...string) func(http.HandlerFunc) http.HandlerFunc {
+	scopes = slices.Clone(scopes)
+	return func(nextFunc http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			t, err := ExtractToken(r.Context())
+			if err != nil {
+				respondTokenError(w, r, err)
+				return
+			}
+			if !HasScope(t, scopes...) {
+				respondError(w, r, http.StatusForbidden, &TokenError{Reason: ErrInsufficientScope, Err: &scopeError{required: scopes}})
+				return
+			}
+			nextFunc(w, r)
+		}
+	}
+}
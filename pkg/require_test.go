@@ -0,0 +1,339 @@
+package tokeninjector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/prorochestvo/tokeninjector/internal"
+	"github.com/twinj/uuid"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenHandler_RequireToken(t *testing.T) {
+	secretKey := uuid.NewV4().Bytes()
+	cookieName := uuid.NewV4().String()
+	userID := uuid.NewV4().String()
+
+	validValue, err := Marshal(userID, uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Hour), secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiredValue, err := Marshal(userID, uuid.NewV4().String(), rand.Uint64(), time.Now().Add(-time.Hour), secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := TokenHandler(secretKey, cookieName, func(w http.ResponseWriter, r *http.Request) {
+		v, err := ExtractToken(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(v.UserID()))
+	}, WithRequireToken())
+	if err != nil {
+		t.Fatalf("could not create nextHandler; details: %s", err.Error())
+	}
+
+	tests := []struct {
+		name      string
+		cookie    string
+		code      int
+		challenge string
+	}{
+		{"valid", validValue, http.StatusOK, ""},
+		{"missing", "", http.StatusUnauthorized, `Bearer`},
+		{"expired", expiredValue, http.StatusUnauthorized, `Bearer error="invalid_token", error_description="token is expired"`},
+		{"malformed", "*", http.StatusUnauthorized, `Bearer error="invalid_token", error_description="token is malformed"`},
+	}
+	for _, tt := range tests {
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if len(tt.cookie) > 0 {
+			req.AddCookie(&http.Cookie{Name: cookieName, Value: tt.cookie, HttpOnly: true})
+		}
+
+		h(res, req)
+		if res.Code != tt.code {
+			t.Errorf("%s: incorrect response code, got %d", tt.name, res.Code)
+		}
+		if s := res.Header().Get("WWW-Authenticate"); s != tt.challenge {
+			t.Errorf("%s: incorrect challenge, got %s", tt.name, s)
+		}
+		if s := res.Body.String(); tt.code == http.StatusOK && s != userID {
+			t.Errorf("%s: incorrect response body, got %s", tt.name, s)
+		}
+	}
+}
+
+func TestRequireToken(t *testing.T) {
+	secretKey := uuid.NewV4().Bytes()
+	cookieName := uuid.NewV4().String()
+
+	h, err := TokenHandler(secretKey, cookieName, RequireToken(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	if err != nil {
+		t.Fatalf("could not create nextHandler; details: %s", err.Error())
+	}
+
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: cookieName, Value: uuid.NewV4().String(), HttpOnly: true})
+
+	h(res, req)
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("incorrect response code, got %d", res.Code)
+	}
+	if s := res.Header().Get("WWW-Authenticate"); !strings.HasPrefix(s, `Bearer error="invalid_token"`) {
+		t.Errorf("incorrect challenge, got %s", s)
+	}
+}
+
+func TestDefaultErrorResponder(t *testing.T) {
+	err := newTokenError(ErrExpired, "secret details <b>")
+
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "application/json")
+	DefaultErrorResponder(res, req, http.StatusUnauthorized, err)
+	var body struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if e := json.Unmarshal(res.Body.Bytes(), &body); e != nil {
+		t.Errorf("incorrect json body, got %s", res.Body.String())
+	}
+	if body.Error != "invalid_token" || body.ErrorDescription != ErrExpired.Error() {
+		t.Errorf("incorrect json body, got %s", res.Body.String())
+	}
+	if s := res.Header().Get("Content-Type"); !strings.HasPrefix(s, "application/json") {
+		t.Errorf("incorrect content type, got %s", s)
+	}
+
+	res = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	DefaultErrorResponder(res, req, http.StatusForbidden, err)
+	if s := res.Body.String(); !strings.Contains(s, "<h1>403 Forbidden</h1>") || strings.Contains(s, "secret") {
+		t.Errorf("incorrect html body, got %s", s)
+	}
+	if res.Code != http.StatusForbidden {
+		t.Errorf("incorrect response code, got %d", res.Code)
+	}
+
+	res = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	DefaultErrorResponder(res, req, http.StatusUnauthorized, err)
+	if s := res.Body.String(); s != fmt.Sprintf("invalid_token: %s\n", ErrExpired.Error()) {
+		t.Errorf("incorrect text body, got %s", s)
+	}
+}
+
+func TestWithErrorResponder(t *testing.T) {
+	secretKey := uuid.NewV4().Bytes()
+
+	var responderErr error
+	h, err := TokenHandler(secretKey, uuid.NewV4().String(), func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}, WithRequireToken(), WithErrorResponder(func(w http.ResponseWriter, r *http.Request, status int, err error) {
+		responderErr = err
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	if err != nil {
+		t.Fatalf("could not create nextHandler; details: %s", err.Error())
+	}
+
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(internal.HeaderAuthorization, fmt.Sprintf("%s %s", internal.AuthMethodBasic, uuid.NewV4().String()))
+
+	h(res, req)
+	if res.Code != http.StatusTeapot {
+		t.Errorf("incorrect response code, got %d", res.Code)
+	}
+	if !errors.Is(responderErr, ErrTokenNotFound) {
+		t.Errorf("incorrect error, got %v", responderErr)
+	}
+	if s := res.Header().Get("WWW-Authenticate"); s != internal.AuthMethodBearer {
+		t.Errorf("incorrect challenge, got %s", s)
+	}
+}
+
+func TestRequireRole_ServeMux(t *testing.T) {
+	const (
+		viewer uint64 = iota + 1
+		editor
+		admin
+	)
+	secretKey := uuid.NewV4().Bytes()
+	cookieName := uuid.NewV4().String()
+
+	roles := NewRoleHierarchy()
+	if err := roles.Inherit(admin, editor); err != nil {
+		t.Fatal(err)
+	}
+	if err := roles.Inherit(editor, viewer); err != nil {
+		t.Fatal(err)
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.PathValue("id")))
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /documents/{id}", roles.RequireRole(viewer)(ok))
+	mux.HandleFunc("DELETE /documents/{id}", roles.RequireRole(admin)(ok))
+	mux.HandleFunc("GET /reports/{id}", RequireRole(editor, admin)(ok))
+
+	h, err := TokenHandler(secretKey, cookieName, mux.ServeHTTP)
+	if err != nil {
+		t.Fatalf("could not create nextHandler; details: %s", err.Error())
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		roleID uint64
+		code   int
+	}{
+		{http.MethodGet, "/documents/1", viewer, http.StatusOK},
+		{http.MethodGet, "/documents/1", admin, http.StatusOK},
+		{http.MethodDelete, "/documents/1", editor, http.StatusForbidden},
+		{http.MethodDelete, "/documents/1", admin, http.StatusOK},
+		{http.MethodGet, "/reports/1", editor, http.StatusOK},
+		{http.MethodGet, "/reports/1", viewer, http.StatusForbidden},
+		{http.MethodGet, "/documents/1", 0, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.Header.Set("Accept", "application/json")
+		if tt.roleID > 0 {
+			cookieValue, err := Marshal(uuid.NewV4().String(), uuid.NewV4().String(), tt.roleID, time.Now().Add(time.Hour), secretKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.AddCookie(&http.Cookie{Name: cookieName, Value: cookieValue, HttpOnly: true})
+		}
+
+		h(res, req)
+		if res.Code != tt.code {
+			t.Errorf("%s %s by %d: incorrect response code, got %d", tt.method, tt.path, tt.roleID, res.Code)
+		}
+		if tt.code == http.StatusOK {
+			if s := res.Body.String(); s != "1" {
+				t.Errorf("%s %s by %d: incorrect response body, got %s", tt.method, tt.path, tt.roleID, s)
+			}
+			continue
+		}
+		var body struct {
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil || len(body.Error) == 0 {
+			t.Errorf("%s %s by %d: incorrect response body, got %s", tt.method, tt.path, tt.roleID, res.Body.String())
+		}
+		if tt.code == http.StatusForbidden && res.Header().Get("WWW-Authenticate") != `Bearer error="access_denied", error_description="token role is not allowed"` {
+			t.Errorf("%s %s by %d: incorrect challenge, got %s", tt.method, tt.path, tt.roleID, res.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	secretKey := uuid.NewV4().Bytes()
+	cookieName := uuid.NewV4().String()
+
+	registry := NewPermissionRegistry()
+	read, err := registry.Register("read", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write, err := registry.Register("write", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /all", RequirePermission(read|write)(ok))
+	mux.HandleFunc("GET /any", RequireAnyPermission(read|write)(ok))
+
+	h, err := TokenHandler(secretKey, cookieName, mux.ServeHTTP)
+	if err != nil {
+		t.Fatalf("could not create nextHandler; details: %s", err.Error())
+	}
+
+	tests := []struct {
+		path       string
+		permission Permission
+		code       int
+	}{
+		{"/all", read | write, http.StatusOK},
+		{"/all", read, http.StatusForbidden},
+		{"/any", write, http.StatusOK},
+		{"/any", 1 << 5, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		cookieValue, err := Marshal(uuid.NewV4().String(), uuid.NewV4().String(), uint64(tt.permission), time.Now().Add(time.Hour), secretKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.AddCookie(&http.Cookie{Name: cookieName, Value: cookieValue, HttpOnly: true})
+
+		h(res, req)
+		if res.Code != tt.code {
+			t.Errorf("%s by %#x: incorrect response code, got %d", tt.path, tt.permission, res.Code)
+		}
+		if s := res.Header().Get("WWW-Authenticate"); tt.code == http.StatusForbidden && !strings.HasPrefix(s, `Bearer error="access_denied"`) {
+			t.Errorf("%s by %#x: incorrect challenge, got %s", tt.path, tt.permission, s)
+		}
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	secretKey := uuid.NewV4().Bytes()
+	cookieName := uuid.NewV4().String()
+
+	h, err := TokenHandler(secretKey, cookieName, RequireScope("orders:read", "users:read")(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	if err != nil {
+		t.Fatalf("could not create nextHandler; details: %s", err.Error())
+	}
+
+	tests := []struct {
+		scopes    []string
+		code      int
+		challenge string
+	}{
+		{[]string{"orders:*", "users:read"}, http.StatusOK, ""},
+		{[]string{"*"}, http.StatusOK, ""},
+		{[]string{"orders:read"}, http.StatusForbidden, `Bearer error="insufficient_scope", error_description="token scope is insufficient", scope="orders:read users:read"`},
+		{nil, http.StatusForbidden, `Bearer error="insufficient_scope", error_description="token scope is insufficient", scope="orders:read users:read"`},
+	}
+	for _, tt := range tests {
+		cookieValue, err := Marshal(uuid.NewV4().String(), uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Hour), secretKey, WithScopes(tt.scopes...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: cookieName, Value: cookieValue, HttpOnly: true})
+
+		h(res, req)
+		if res.Code != tt.code {
+			t.Errorf("%v: incorrect response code, got %d", tt.scopes, res.Code)
+		}
+		if s := res.Header().Get("WWW-Authenticate"); s != tt.challenge {
+			t.Errorf("%v: incorrect challenge, got %s", tt.scopes, s)
+		}
+	}
+}
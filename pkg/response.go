@@ -0,0 +1,110 @@
+package tokeninjector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/prorochestvo/tokeninjector/internal"
+	"html"
+	"net/http"
+	"strings"
+)
+
+// ErrorResponder writes the response of the rejected request, e.g. 401 if the request has no valid token.
+// The status and the WWW-Authenticate challenge are chosen by the middleware, the challenge is already set
+// when the responder is called, the responder writes the status and the body.
+type ErrorResponder func(w http.ResponseWriter, r *http.Request, status int, err error)
+
+// DefaultErrorResponder writes the error code and the reason of the rejection in the format accepted by the client:
+// a JSON object for JSON APIs, a simple page for browsers and a plain text otherwise.
+// The cause of the rejection is not disclosed, it may contain the internals (e.g. the key id).
+func DefaultErrorResponder(w http.ResponseWriter, r *http.Request, status int, err error) {
+	code := errorCode(status, err)
+	description := errorDescription(err)
+
+	accept := r.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, "application/json") || strings.Contains(accept, "+json"):
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+		}{code, description})
+	case strings.Contains(accept, "text/html"):
+		title := html.EscapeString(fmt.Sprintf("%d %s", status, http.StatusText(status)))
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		_, _ = fmt.Fprintf(w, "<!DOCTYPE html>\n<html><head><title>%s</title></head><body><h1>%s</h1><p>%s</p></body></html>\n", title, title, html.EscapeString(description))
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(status)
+		_, _ = fmt.Fprintf(w, "%s: %s\n", code, description)
+	}
+}
+
+// respondError sets the RFC 6750 challenge and writes the response with the responder of the middleware,
+// the DefaultErrorResponder is used if the middleware has none.
+func respondError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	if status == http.StatusUnauthorized || status == http.StatusForbidden {
+		w.Header().Set("WWW-Authenticate", bearerChallenge(status, err))
+	}
+	responder, ok := r.Context().Value(contextKeyErrorResponder{}).(ErrorResponder)
+	if !ok || responder == nil {
+		responder = DefaultErrorResponder
+	}
+	responder(w, r, status, err)
+}
+
+// bearerChallenge returns the value of the WWW-Authenticate header, the error code is omitted
+// if the request has no token at all (RFC 6750, section 3.1).
+func bearerChallenge(status int, err error) string {
+	if status == http.StatusUnauthorized && errors.Is(err, ErrTokenNotFound) {
+		return internal.AuthMethodBearer
+	}
+	unquote := strings.NewReplacer(`\`, "", `"`, "")
+	description := unquote.Replace(errorDescription(err))
+	challenge := fmt.Sprintf(`%s error="%s", error_description="%s"`, internal.AuthMethodBearer, errorCode(status, err), description)
+	var e *scopeError
+	if errors.As(err, &e) {
+		challenge += fmt.Sprintf(`, scope="%s"`, unquote.Replace(strings.Join(e.required, " ")))
+	}
+	return challenge
+}
+
+// errorCode returns the RFC 6750 error code of the response status,
+// the rejected refresh tokens get the RFC 6749 (section 5.2) "invalid_grant" and the requests
+// rejected for the role or the permissions get the RFC 6749 (section 4.1.2.1) "access_denied".
+func errorCode(status int, err error) string {
+	switch status {
+	case http.StatusBadRequest:
+		if errors.Is(err, ErrRefreshInvalid) || errors.Is(err, ErrRefreshReused) || errors.Is(err, ErrExpired) {
+			return "invalid_grant"
+		}
+		return "invalid_request"
+	case http.StatusForbidden:
+		if errors.Is(err, ErrInsufficientScope) {
+			return "insufficient_scope"
+		}
+		return "access_denied"
+	case http.StatusServiceUnavailable:
+		return "temporarily_unavailable"
+	default:
+		return "invalid_token"
+	}
+}
+
+// errorDescription returns the reason of the rejection without the cause,
+// the errors other than TokenError and ErrTokenNotFound may contain the internals and are not described.
+func errorDescription(err error) string {
+	var e *TokenError
+	switch {
+	case errors.As(err, &e):
+		return e.Reason.Error()
+	case errors.Is(err, ErrTokenNotFound):
+		return ErrTokenNotFound.Error()
+	default:
+		return "request is rejected"
+	}
+}
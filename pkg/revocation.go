@@ -0,0 +1,238 @@
+package tokeninjector
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RevocationStore keeps the ids of the revoked tokens until the tokens expire, see WithRevocationCheck.
+type RevocationStore interface {
+	// Revoke revokes the token with the id, the record may be removed after the expiration time.
+	Revoke(tokenID string, expiredAt time.Time) error
+	// IsRevoked reports whether the token with the id is revoked.
+	IsRevoked(tokenID string) (bool, error)
+}
+
+// RevokeToken revokes the token in the store until it expires, e.g. on logout.
+// The token of the sliding session extracted from the context (see WithSlidingExpiration) is revoked until
+// the end of the maximum lifetime of the session, so its renewed copies with the same id stay revoked.
+func RevokeToken(store RevocationStore, t Token) error {
+	if len(t.TokenID()) == 0 {
+		return fmt.Errorf("token has no id")
+	}
+	expiredAt := t.ExpiredAt()
+	if v, ok := t.(*token); ok && v.sessionExpiredAt.After(expiredAt) {
+		expiredAt = v.sessionExpiredAt
+	}
+	return store.Revoke(t.TokenID(), expiredAt)
+}
+
+// sweepInterval is the minimal interval between the removals of the expired records.
+const sweepInterval = time.Minute
+
+// MemoryRevocationStore is the RevocationStore in memory, the records are removed when the tokens expire.
+type MemoryRevocationStore struct {
+	mutex   sync.RWMutex
+	revoked map[string]time.Time
+	swept   time.Time
+}
+
+// NewMemoryRevocationStore creates an empty MemoryRevocationStore.
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{revoked: make(map[string]time.Time), swept: time.Now()}
+}
+
+// Revoke revokes the token with the id until the expiration time.
+func (s *MemoryRevocationStore) Revoke(tokenID string, expiredAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.swept) >= sweepInterval {
+		s.sweep(now)
+	}
+	if e, ok := s.revoked[tokenID]; !ok || e.Before(expiredAt) {
+		s.revoked[tokenID] = expiredAt
+	}
+
+	return nil
+}
+
+// IsRevoked reports whether the token with the id is revoked and not expired yet.
+func (s *MemoryRevocationStore) IsRevoked(tokenID string) (bool, error) {
+	s.mutex.RLock()
+	expiredAt, ok := s.revoked[tokenID]
+	s.mutex.RUnlock()
+
+	return ok && time.Now().Before(expiredAt), nil
+}
+
+// sweep removes the records of the expired tokens, the caller holds the lock.
+func (s *MemoryRevocationStore) sweep(now time.Time) {
+	for tokenID, expiredAt := range s.revoked {
+		if !now.Before(expiredAt) {
+			delete(s.revoked, tokenID)
+		}
+	}
+	s.swept = now
+}
+
+// FileRevocationStore is the RevocationStore backed by the file, so the revocations survive restarts
+// and are shared by the processes on the same host. Every revocation is appended to the file as the line
+// "<expiration unix time> <token id>", the file is reloaded when another process changes it.
+// The records of the expired tokens are kept in the file until Compact.
+type FileRevocationStore struct {
+	path    string
+	mutex   sync.Mutex
+	memory  *MemoryRevocationStore
+	modTime time.Time
+	size    int64
+	checked time.Time
+}
+
+// NewFileRevocationStore creates the store of the file, the file is created if it does not exist.
+func NewFileRevocationStore(path string) (*FileRevocationStore, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err = f.Close(); err != nil {
+		return nil, err
+	}
+	s := &FileRevocationStore{path: path, memory: NewMemoryRevocationStore()}
+	if err = s.reload(time.Now()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Revoke appends the revocation to the file and syncs it.
+func (s *FileRevocationStore) Revoke(tokenID string, expiredAt time.Time) error {
+	if len(tokenID) == 0 || strings.ContainsAny(tokenID, "\r\n") {
+		return fmt.Errorf("token id %q can not be stored", tokenID)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%d %s\n", expiredAt.Unix(), tokenID)
+	if err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.memory.Revoke(tokenID, expiredAt)
+}
+
+// IsRevoked reports whether the token with the id is revoked, the file is reloaded if it was changed.
+func (s *FileRevocationStore) IsRevoked(tokenID string) (bool, error) {
+	s.mutex.Lock()
+	if now := time.Now(); now.Sub(s.checked) >= fileCheckInterval {
+		if err := s.reload(now); err != nil {
+			s.mutex.Unlock()
+			return false, err
+		}
+	}
+	s.mutex.Unlock()
+
+	return s.memory.IsRevoked(tokenID)
+}
+
+// Compact rewrites the file without the records of the expired tokens.
+// The file is replaced, so the revocations appended by other processes during Compact may be lost.
+func (s *FileRevocationStore) Compact() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.reload(time.Now()); err != nil {
+		return err
+	}
+
+	s.memory.mutex.Lock()
+	s.memory.sweep(time.Now())
+	var b bytes.Buffer
+	for tokenID, expiredAt := range s.memory.revoked {
+		_, _ = fmt.Fprintf(&b, "%d %s\n", expiredAt.Unix(), tokenID)
+	}
+	s.memory.mutex.Unlock()
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b.Bytes(), 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.checked = time.Time{}
+
+	return nil
+}
+
+// reload loads the file if it was modified since the last load, the caller holds the lock.
+func (s *FileRevocationStore) reload(now time.Time) error {
+	s.checked = now
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	revoked, err := parseRevocations(data)
+	if err != nil {
+		return fmt.Errorf("could not parse %s; details: %s", s.path, err.Error())
+	}
+
+	s.memory.mutex.Lock()
+	s.memory.revoked = revoked
+	s.memory.sweep(now)
+	s.memory.mutex.Unlock()
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+
+	return nil
+}
+
+// parseRevocations parses the lines of the revocation file, the last line without the line break
+// is skipped, it is being written by another process.
+func parseRevocations(data []byte) (map[string]time.Time, error) {
+	revoked := make(map[string]time.Time)
+	lines := strings.Split(string(data), "\n")
+	for n, line := range lines[:len(lines)-1] {
+		if len(line) == 0 {
+			continue
+		}
+		unix, tokenID, ok := strings.Cut(line, " ")
+		if !ok || len(tokenID) == 0 {
+			return nil, fmt.Errorf("incorrect line %d", n+1)
+		}
+		v, err := strconv.ParseInt(unix, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect line %d; details: %s", n+1, err.Error())
+		}
+		if expiredAt := time.Unix(v, 0); revoked[tokenID].Before(expiredAt) {
+			revoked[tokenID] = expiredAt
+		}
+	}
+	return revoked, nil
+}
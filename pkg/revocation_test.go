@@ -0,0 +1,273 @@
+package tokeninjector
+
+import (
+	"errors"
+	"github.com/twinj/uuid"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryRevocationStore(t *testing.T) {
+	s := NewMemoryRevocationStore()
+	active := uuid.NewV4().String()
+	expired := uuid.NewV4().String()
+
+	if err := s.Revoke(active, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Revoke(expired, time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if revoked, err := s.IsRevoked(active); err != nil || !revoked {
+		t.Errorf("incorrect revocation of the active token, got %v, %v", revoked, err)
+	}
+	if revoked, err := s.IsRevoked(expired); err != nil || revoked {
+		t.Errorf("incorrect revocation of the expired token, got %v, %v", revoked, err)
+	}
+	if revoked, err := s.IsRevoked(uuid.NewV4().String()); err != nil || revoked {
+		t.Errorf("incorrect revocation of the unknown token, got %v, %v", revoked, err)
+	}
+
+	// the expired records are evicted by the sweep
+	s.swept = time.Time{}
+	if err := s.Revoke(uuid.NewV4().String(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.revoked[expired]; ok {
+		t.Errorf("incorrect sweep, the expired record is kept")
+	}
+	if len(s.revoked) != 2 {
+		t.Errorf("incorrect number of records, got %d", len(s.revoked))
+	}
+}
+
+func TestFileRevocationStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revoked")
+	s, err := NewFileRevocationStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	active := uuid.NewV4().String()
+	expired := uuid.NewV4().String()
+
+	if err = s.Revoke(active, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Revoke(expired, time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Revoke("multi\nline", time.Now().Add(time.Hour)); err == nil {
+		t.Errorf("incorrect revocation, the token id with a line break is accepted")
+	}
+
+	// the revocations survive the restart and are shared with another store
+	other, err := NewFileRevocationStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked, err := other.IsRevoked(active); err != nil || !revoked {
+		t.Errorf("incorrect revocation of the active token, got %v, %v", revoked, err)
+	}
+	if revoked, err := other.IsRevoked(expired); err != nil || revoked {
+		t.Errorf("incorrect revocation of the expired token, got %v, %v", revoked, err)
+	}
+	shared := uuid.NewV4().String()
+	if err = other.Revoke(shared, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	s.checked = time.Time{}
+	if revoked, err := s.IsRevoked(shared); err != nil || !revoked {
+		t.Errorf("incorrect revocation of the shared token, got %v, %v", revoked, err)
+	}
+
+	if err = s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), expired) || !strings.Contains(string(data), active) || !strings.Contains(string(data), shared) {
+		t.Errorf("incorrect compaction, got %s", data)
+	}
+
+	if err = os.WriteFile(path, []byte("broken\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewFileRevocationStore(path); err == nil {
+		t.Errorf("incorrect store, the broken file is accepted")
+	}
+}
+
+func TestTokenHandler_RevocationStore(t *testing.T) {
+	secretKey := uuid.NewV4().Bytes()
+	ring, err := newSingleKeyRing(secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookieName := uuid.NewV4().String()
+	store := NewMemoryRevocationStore()
+
+	cookieValue, err := Marshal(uuid.NewV4().String(), uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Hour), secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tokenErr error
+	h, err := TokenHandler(secretKey, cookieName, func(w http.ResponseWriter, r *http.Request) {
+		tkn, err := ExtractToken(r.Context())
+		if tokenErr = err; err == nil {
+			// logout
+			if err = RevokeToken(store, tkn); err != nil {
+				t.Error(err)
+			}
+		}
+	}, WithRevocationStore(store))
+	if err != nil {
+		t.Fatalf("could not create nextHandler; details: %s", err.Error())
+	}
+
+	for i, expected := range []error{nil, ErrRevoked} {
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: cookieName, Value: cookieValue, HttpOnly: true})
+
+		h(res, req)
+		if (expected == nil && tokenErr != nil) || (expected != nil && !errors.Is(tokenErr, expected)) {
+			t.Errorf("%d: incorrect error, got %v, expected %v", i, tokenErr, expected)
+		}
+	}
+
+	if _, err = UnmarshalToken(cookieValue, ring, WithRevocationCheck(store)); !errors.Is(err, ErrRevoked) {
+		t.Errorf("incorrect error of UnmarshalToken, got %v", err)
+	}
+	if _, err = UnmarshalToken(cookieValue, ring); err != nil {
+		t.Errorf("incorrect error of UnmarshalToken without the check, got %v", err)
+	}
+}
+
+func TestTokenHandler_RevocationStore_SlidingExpiration(t *testing.T) {
+	secretKey := uuid.NewV4().Bytes()
+	cookieName := uuid.NewV4().String()
+	store := NewMemoryRevocationStore()
+	tokenID := uuid.NewV4().String()
+	issuedAt := time.Now().Add(-10 * time.Minute)
+
+	cookieValue, err := Marshal(uuid.NewV4().String(), uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Minute), secretKey, WithIssuedAt(issuedAt), WithTokenID(tokenID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tokenErr error
+	h, err := TokenHandler(secretKey, cookieName, func(w http.ResponseWriter, r *http.Request) {
+		tkn, err := ExtractToken(r.Context())
+		if tokenErr = err; err == nil && r.URL.Path == "/logout" {
+			if err = RevokeToken(store, tkn); err != nil {
+				t.Error(err)
+			}
+		}
+	}, WithSlidingExpiration(5*time.Minute, 30*time.Minute, time.Hour), WithRevocationStore(store))
+	if err != nil {
+		t.Fatalf("could not create nextHandler; details: %s", err.Error())
+	}
+
+	// the stolen copy of the cookie is renewed before the logout
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: cookieName, Value: cookieValue})
+	h(res, req)
+	cookies := res.Result().Cookies()
+	if tokenErr != nil || len(cookies) != 1 {
+		t.Fatalf("cookie was not renewed, got %v", tokenErr)
+	}
+	renewed := cookies[0]
+
+	// the logout with the original cookie
+	res = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/logout", nil)
+	req.AddCookie(&http.Cookie{Name: cookieName, Value: cookieValue})
+	h(res, req)
+	if tokenErr != nil {
+		t.Fatal(tokenErr)
+	}
+
+	// the revocation outlives the renewed copy, so it can not come back after the original expiration
+	if expiredAt := store.revoked[tokenID]; expiredAt.Before(issuedAt.Add(time.Hour-time.Second)) || expiredAt.Before(renewed.Expires) {
+		t.Errorf("incorrect revocation expiration, got %s, renewed copy expires at %s", expiredAt, renewed.Expires)
+	}
+
+	res = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: cookieName, Value: renewed.Value})
+	h(res, req)
+	if !errors.Is(tokenErr, ErrRevoked) {
+		t.Errorf("incorrect error of the renewed copy, got %v", tokenErr)
+	}
+	if len(res.Result().Cookies()) != 0 {
+		t.Errorf("revoked copy was renewed")
+	}
+}
+
+func TestTokenHandler_RevocationStore_Failure(t *testing.T) {
+	secretKey := uuid.NewV4().Bytes()
+	cookieName := uuid.NewV4().String()
+	store := &failingRevocationStore{err: errors.New("could not parse /var/lib/secret/revoked; details: incorrect line 7")}
+	ring, err := newSingleKeyRing(secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cookieValue, err := MarshalWithKeyRing(uuid.NewV4().String(), uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Hour), ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tokenErr error
+	h, err := KeyRingHandler(ring, cookieName, func(w http.ResponseWriter, r *http.Request) {
+		_, tokenErr = ExtractToken(r.Context())
+	}, WithRevocationStore(store), WithRequireToken())
+	if err != nil {
+		t.Fatalf("could not create nextHandler; details: %s", err.Error())
+	}
+
+	for _, accept := range []string{"application/json", "text/html", ""} {
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", accept)
+		req.AddCookie(&http.Cookie{Name: cookieName, Value: cookieValue})
+
+		h(res, req)
+		if res.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s: incorrect response code, got %d", accept, res.Code)
+		}
+		if s := res.Body.String(); accept == "application/json" && !strings.Contains(s, "temporarily_unavailable") {
+			t.Errorf("%s: incorrect error code, got %s", accept, s)
+		}
+		if s := res.Body.String(); strings.Contains(s, "/var/lib") || !strings.Contains(s, ErrUnavailable.Error()) {
+			t.Errorf("%s: incorrect response, got %s", accept, s)
+		}
+	}
+
+	if _, err = UnmarshalToken(cookieValue, ring, WithRevocationCheck(store)); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("incorrect error of the failed store, got %v", err)
+	}
+	if tokenErr != nil {
+		t.Errorf("next handler was called, got %v", tokenErr)
+	}
+}
+
+// failingRevocationStore is the RevocationStore that always fails.
+type failingRevocationStore struct {
+	err error
+}
+
+func (s *failingRevocationStore) Revoke(string, time.Time) error { return s.err }
+
+func (s *failingRevocationStore) IsRevoked(string) (bool, error) { return false, s.err }
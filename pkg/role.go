@@ -0,0 +1,67 @@
+package tokeninjector
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// RoleHierarchy defines which role ids inherit the access of other role ids,
+// e.g. the administrator inherits the access of the editor and the editor inherits the access of the viewer.
+// The inheritance is transitive, cycles are rejected.
+type RoleHierarchy struct {
+	mutex     sync.RWMutex
+	inherited map[uint64][]uint64
+}
+
+// NewRoleHierarchy creates an empty role hierarchy, each role has only its own access.
+func NewRoleHierarchy() *RoleHierarchy {
+	return &RoleHierarchy{inherited: make(map[uint64][]uint64)}
+}
+
+// Inherit makes the role inherit the access of the inherited roles.
+func (h *RoleHierarchy) Inherit(roleID uint64, inherited ...uint64) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for _, id := range inherited {
+		if h.includes(id, roleID) {
+			return fmt.Errorf("role %d already inherits role %d, cycles are not allowed", id, roleID)
+		}
+	}
+	h.inherited[roleID] = append(h.inherited[roleID], inherited...)
+
+	return nil
+}
+
+// Includes reports whether the role is the required role or inherits its access.
+func (h *RoleHierarchy) Includes(roleID uint64, requiredRoleID uint64) bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	return h.includes(roleID, requiredRoleID)
+}
+
+// includes is the Includes without the lock, it walks the inherited roles in depth.
+func (h *RoleHierarchy) includes(roleID uint64, requiredRoleID uint64) bool {
+	visited := map[uint64]bool{}
+	stack := []uint64{roleID}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if id == requiredRoleID {
+			return true
+		}
+		if visited[id] {
+			continue
+		}
+		visited[id] = true
+		stack = append(stack, h.inherited[id]...)
+	}
+	return false
+}
+
+// RequireRole is like the package RequireRole, but the token role is also allowed if it inherits one of the roles.
+func (h *RoleHierarchy) RequireRole(roles ...uint64) func(http.HandlerFunc) http.HandlerFunc {
+	return requireRole(roles, h.Includes)
+}
@@ -0,0 +1,51 @@
+package tokeninjector
+
+import (
+	"testing"
+)
+
+func TestRoleHierarchy(t *testing.T) {
+	const (
+		viewer uint64 = iota + 1
+		editor
+		admin
+		auditor
+	)
+
+	h := NewRoleHierarchy()
+	if err := h.Inherit(editor, viewer); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Inherit(admin, editor, auditor); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		roleID   uint64
+		required uint64
+		expected bool
+	}{
+		{viewer, viewer, true},
+		{editor, viewer, true},
+		{admin, viewer, true},
+		{admin, auditor, true},
+		{viewer, editor, false},
+		{auditor, viewer, false},
+		{editor, admin, false},
+	}
+	for _, tt := range tests {
+		if v := h.Includes(tt.roleID, tt.required); v != tt.expected {
+			t.Errorf("incorrect includes of %d by %d, got %v", tt.required, tt.roleID, v)
+		}
+	}
+
+	if err := h.Inherit(viewer, admin); err == nil {
+		t.Errorf("incorrect inherit, the cycle is accepted")
+	}
+	if err := h.Inherit(viewer, viewer); err == nil {
+		t.Errorf("incorrect inherit, the self reference is accepted")
+	}
+	if h.Includes(viewer, admin) {
+		t.Errorf("incorrect includes, the rejected cycle is stored")
+	}
+}
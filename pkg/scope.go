@@ -0,0 +1,77 @@
+package tokeninjector
+
+import (
+	"fmt"
+	"strings"
+)
+
+// scopeSeparator separates the segments of the hierarchical scopes, e.g. "orders:read".
+const scopeSeparator = ":"
+
+// scopeWildcard is the segment of the granted scope that matches any segment, as the last segment
+// it matches any number of segments, e.g. "orders:*" matches "orders:read" and "orders:items:write".
+const scopeWildcard = "*"
+
+// MatchScope reports whether the granted scope includes the required scope.
+// The required scope is matched literally, only the granted scope may contain wildcards.
+func MatchScope(granted string, required string) bool {
+	g := strings.Split(granted, scopeSeparator)
+	r := strings.Split(required, scopeSeparator)
+	for i, segment := range g {
+		if i >= len(r) {
+			return false
+		}
+		if segment == scopeWildcard {
+			if i == len(g)-1 {
+				return true
+			}
+			continue
+		}
+		if segment != r[i] {
+			return false
+		}
+	}
+	return len(g) == len(r)
+}
+
+// HasScope reports whether the scopes of the token include all the required scopes.
+func HasScope(t Token, required ...string) bool {
+	granted := t.Scopes()
+	for _, r := range required {
+		matched := false
+		for _, g := range granted {
+			if matched = MatchScope(g, r); matched {
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// checkScopes checks that the scopes can be stored in the space-delimited list of RFC 6749 (section 3.3).
+func checkScopes(scopes []string) error {
+	for _, s := range scopes {
+		if len(s) == 0 {
+			return fmt.Errorf("scope is empty")
+		}
+		for _, c := range []byte(s) {
+			if c < 0x21 || c > 0x7E || c == '"' || c == '\\' {
+				return fmt.Errorf("scope %q contains an incorrect character", s)
+			}
+		}
+	}
+	return nil
+}
+
+// scopeError is the cause of the rejection by RequireScope, the required scopes are sent in the challenge.
+type scopeError struct {
+	required []string
+}
+
+// Error returns the required scopes.
+func (e *scopeError) Error() string {
+	return fmt.Sprintf("required scopes %q", e.required)
+}
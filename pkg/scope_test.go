@@ -0,0 +1,71 @@
+package tokeninjector
+
+import (
+	"github.com/twinj/uuid"
+	"math/rand"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMatchScope(t *testing.T) {
+	tests := []struct {
+		granted  string
+		required string
+		expected bool
+	}{
+		{"orders:read", "orders:read", true},
+		{"orders:read", "orders:write", false},
+		{"orders:*", "orders:read", true},
+		{"orders:*", "orders:items:write", true},
+		{"orders:*", "orders", false},
+		{"orders:*", "users:read", false},
+		{"*", "orders:read", true},
+		{"orders:*:read", "orders:items:read", true},
+		{"orders:*:read", "orders:items:write", false},
+		{"orders:*:read", "orders:items:read:all", false},
+		{"orders", "orders:read", false},
+		{"orders:read", "orders:*", false},
+	}
+	for _, tt := range tests {
+		if v := MatchScope(tt.granted, tt.required); v != tt.expected {
+			t.Errorf("incorrect match of %s by %s, got %v", tt.required, tt.granted, v)
+		}
+	}
+}
+
+func TestMarshal_Scopes(t *testing.T) {
+	secretKey := uuid.NewV4().Bytes()
+	ring, err := newSingleKeyRing(secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scopes := []string{"orders:*", "users:read"}
+
+	s, err := Marshal(uuid.NewV4().String(), uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Hour), secretKey, WithScopes(scopes...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := UnmarshalToken(s, ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(v.Scopes(), scopes) {
+		t.Errorf("incorrect scopes, got %v", v.Scopes())
+	}
+	if !HasScope(v, "orders:write", "users:read") {
+		t.Errorf("incorrect has scope, got false")
+	}
+	if HasScope(v, "orders:write", "users:write") {
+		t.Errorf("incorrect has scope, got true")
+	}
+	if len(v.Claims().Tags()) != 0 {
+		t.Errorf("incorrect claims, got %v", v.Claims().Tags())
+	}
+
+	for _, scope := range []string{"", "orders read", `orders"`, "заказы"} {
+		if _, err = Marshal(uuid.NewV4().String(), uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Hour), secretKey, WithScopes(scope)); err == nil {
+			t.Errorf("incorrect scope %q is accepted", scope)
+		}
+	}
+}
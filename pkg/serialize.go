@@ -2,333 +2,336 @@ package tokeninjector
 
 import (
 	"encoding/base64"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"github.com/prorochestvo/tokeninjector/internal/crypto/aes"
 	"github.com/twinj/uuid"
-	"hash/crc32"
-	"math/bits"
-	"math/rand"
 	"time"
 )
 
-// Marshal creates a token string from the user id, user name, role id, and expiration time.
-// The token string is encrypted with the secret key and encoded in base64.
-func Marshal(userID string, userName string, roleID uint64, expiredAt time.Time, secretKey []byte) (string, error) {
-	dataset := convertToByte(
-		[]byte(userID),
-		[]byte(userName),
-		roleID,
-		uint64(expiredAt.UTC().Unix()),
-	)
-
-	crypted, err := encrypt(dataset, secretKey)
+// Marshal creates a token string from the user id, user name, role id, and expiration time,
+// the optional fields (e.g. additional claims) are set by the options.
+// The token string is encrypted and authenticated with the secret key (AES-GCM) and encoded in base64.
+func Marshal(userID string, userName string, roleID uint64, expiredAt time.Time, secretKey []byte, opts ...MarshalOption) (string, error) {
+	ring, err := newSingleKeyRing(secretKey)
 	if err != nil {
 		return "", err
 	}
-
-	external := base64.StdEncoding.EncodeToString(crypted)
-
-	return external, nil
+	return MarshalWithKeyRing(userID, userName, roleID, expiredAt, ring, opts...)
 }
 
 // Unmarshal extracts the user id, user name, role id, and expiration time from the token string.
-// The token string is decoded from base64 and decrypted with the secret key.
-func Unmarshal(data string, secretKey []byte) (userID string, userName string, roleID uint64, expiredAt time.Time, err error) {
-	dataset, err := base64.StdEncoding.DecodeString(data)
+// The token string is decoded from base64 and decrypted with the secret key, tampered tokens are rejected.
+// The tokens that are not valid yet are rejected and the checks set by the options (e.g. the audience
+// or the maximum age) are performed, the expiration time is left to the caller.
+func Unmarshal(data string, secretKey []byte, opts ...ValidateOption) (userID string, userName string, roleID uint64, expiredAt time.Time, err error) {
+	ring, err := newSingleKeyRing(secretKey)
 	if err != nil {
 		return
 	}
+	return UnmarshalWithKeyRing(data, ring, opts...)
+}
 
-	internal, err := decrypt(dataset, secretKey)
-	if err != nil {
-		return
+// MarshalWithKeyRing creates a token string like Marshal, but encrypts it with the active key of the key ring.
+// The id of the key is stored in the token header.
+func MarshalWithKeyRing(userID string, userName string, roleID uint64, expiredAt time.Time, ring *KeyRing, opts ...MarshalOption) (string, error) {
+	return marshalToken(newToken(userID, userName, roleID, expiredAt, opts), ring)
+}
+
+// NewToken creates a token like Marshal without encoding it, e.g. for IssueCookie or WithToken.
+func NewToken(userID string, userName string, roleID uint64, expiredAt time.Time, opts ...MarshalOption) Token {
+	return newToken(userID, userName, roleID, expiredAt, opts)
+}
+
+// newToken creates the token with the default issue time and random token id, then applies the options.
+func newToken(userID string, userName string, roleID uint64, expiredAt time.Time, opts []MarshalOption) *token {
+	t := &token{
+		userID:    userID,
+		userName:  userName,
+		roleID:    roleID,
+		expiredAt: expiredAt,
+		issuedAt:  time.Now(),
+		tokenID:   uuid.NewV4().String(),
+	}
+	for _, opt := range opts {
+		opt(t)
 	}
+	return t
+}
 
-	uID, uName, uRole, expired, err := convertFromByte(internal)
+// UnmarshalWithKeyRing extracts the token fields like Unmarshal, but decrypts the token string
+// with the key of the key ring referenced by the token header.
+func UnmarshalWithKeyRing(data string, ring *KeyRing, opts ...ValidateOption) (userID string, userName string, roleID uint64, expiredAt time.Time, err error) {
+	t, _, err := unmarshalToken(data, ring)
 	if err != nil {
 		return
 	}
+	validation := newValidateOptions(opts)
+	validation.skipExpiry = true
+	if err = validateToken(t, validation); err != nil {
+		return
+	}
+	return t.userID, t.userName, t.roleID, t.expiredAt, nil
+}
 
-	userID = string(uID)
-	userName = string(uName)
-	roleID = uRole
-	expiredAt = time.Unix(int64(expired), 0).UTC()
-
-	return
+// UnmarshalToken extracts the token with all its claims from the token string decrypted with the key ring.
+// Unlike Unmarshal, it rejects expired tokens and performs the checks set by the options.
+func UnmarshalToken(data string, ring *KeyRing, opts ...ValidateOption) (Token, error) {
+	t, _, err := unmarshalToken(data, ring)
+	if err != nil {
+		return nil, err
+	}
+	if err = validateToken(t, newValidateOptions(opts)); err != nil {
+		return nil, err
+	}
+	return t, nil
 }
 
-// convertToByte creates a byte array from the user id, user name, role id, and expiration time.
-func convertToByte(userId []byte, userName []byte, userRoleId uint64, expiredAt uint64) []byte {
-	salt := uuid.NewV4().Bytes()
+// MaxUserIDLength and MaxUserNameLength are the maximum lengths (in bytes) of the user id and user name
+// accepted by Marshal, longer values are rejected with an error.
+const (
+	MaxUserIDLength   = 1024
+	MaxUserNameLength = 1024
+)
 
-	lUserName := len(userName)
-	lUserName = max(lUserName, 0)
-	lUserName = min(lUserName, 254)
+// marshalToken creates a token string from the token, it is encrypted with the active key of the key ring.
+func marshalToken(t *token, ring *KeyRing) (string, error) {
+	if ring == nil {
+		return "", fmt.Errorf("key ring is not defined")
+	}
 
-	lUserID := len(userId)
-	lUserID = max(lUserID, 0)
-	lUserID = min(lUserID, 100)
+	dataset, err := convertToByte(t)
+	if err != nil {
+		return "", err
+	}
 
-	b := make([]byte, lUserName+lUserID+40)
-	l := 0
+	crypted, err := encrypt(dataset, ring)
+	if err != nil {
+		return "", err
+	}
 
-	// salt prefix
-	for i := 0; i < 8; i++ {
-		b[l+i] = salt[i]
-	}
-	l += 8
-
-	// user name
-	xorUserName := uint8(0)
-	b[l+0] = 'N'
-	b[l+1] = uint8(lUserName)
-	l += 2
-	for i := 0; i < lUserName; i++ {
-		b[l+i] = userName[i]
-		xorUserName = xorUserName ^ userName[i]
-	}
-	l += lUserName
-
-	// user id
-	xorUserID := uint8(0)
-	b[l+0] = 'I'
-	b[l+1] = uint8(lUserID)
-	l += 2
-	for i := 0; i < lUserID; i++ {
-		b[l+i] = userId[i]
-		xorUserID = xorUserID ^ userId[i]
-	}
-	l += lUserID
-
-	// expired at
-	b[l+0] = uint8((expiredAt & 0xFF00000000000000) >> 56)
-	b[l+1] = uint8((expiredAt & 0xFF000000000000) >> 48)
-	b[l+2] = uint8((expiredAt & 0xFF0000000000) >> 40)
-	b[l+3] = uint8((expiredAt & 0xFF00000000) >> 32)
-	b[l+4] = uint8((expiredAt & 0xFF000000) >> 24)
-	b[l+5] = uint8((expiredAt & 0xFF0000) >> 16)
-	b[l+6] = uint8((expiredAt & 0xFF00) >> 8)
-	b[l+7] = uint8(expiredAt & 0xFF)
-	l += 8
-
-	// role id
-	b[l+0] = uint8((userRoleId & 0xFF00000000000000) >> 56)
-	b[l+1] = uint8((userRoleId & 0xFF000000000000) >> 48)
-	b[l+2] = uint8((userRoleId & 0xFF0000000000) >> 40)
-	b[l+3] = uint8((userRoleId & 0xFF00000000) >> 32)
-	b[l+4] = uint8((userRoleId & 0xFF000000) >> 24)
-	b[l+5] = uint8((userRoleId & 0xFF0000) >> 16)
-	b[l+6] = uint8((userRoleId & 0xFF00) >> 8)
-	b[l+7] = uint8(userRoleId & 0xFF)
-	l += 8
-
-	// salt suffix
-	for i := 0; i < 8; i++ {
-		b[l+i] = salt[i+8]
-	}
-	l += 8
-
-	// hash
-	b[l+3] = uint8((lUserName ^ lUserID) & 0xFF)
-	b[l+2] = xorUserID
-	b[l+1] = xorUserName
-	b[l+0] = uint8((expiredAt ^ userRoleId) & 0xFF)
-	l += 4
-
-	return b
+	external := base64.StdEncoding.EncodeToString(crypted)
+
+	return external, nil
 }
 
-// convertFromByte extracts the user id, user name, role id, and expiration time from the byte array.
-func convertFromByte(data []byte) (userId []byte, userName []byte, userRoleId uint64, expiredAt uint64, err error) {
-	if len(data) <= 40 {
-		err = fmt.Errorf("incorrect dataset size")
-		return
+// unmarshalToken extracts the token from the token string decrypted with the key ring.
+// The outdated flag reports that the token was encrypted with a non-active key or in the v1 format.
+func unmarshalToken(data string, ring *KeyRing) (t *token, outdated bool, err error) {
+	if ring == nil {
+		return nil, false, fmt.Errorf("key ring is not defined")
 	}
 
-	l := 0
+	dataset, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, false, asTokenError(ErrMalformed, err)
+	}
 
-	// user name
-	l += 8
-	if data[l] != 'N' {
-		err = fmt.Errorf("incorrect dataset")
-		return
+	internal, version, outdated, err := decrypt(dataset, ring)
+	if err != nil {
+		return nil, false, err
 	}
-	l += 2
-	lUserName := int(data[l-1])
-	lUserName = max(lUserName, 0)
-	lUserName = min(lUserName, 254)
-	userName = data[l : l+lUserName]
-	l += lUserName
 
-	// user id
-	if data[l] != 'I' {
-		err = fmt.Errorf("incorrect dataset")
-		return
+	if version == tokenVersionLegacy {
+		uID, uName, uRole, expired, err := convertFromByteLegacy(internal)
+		if err != nil {
+			return nil, false, asTokenError(ErrMalformed, err)
+		}
+		t = &token{
+			userID:    string(uID),
+			userName:  string(uName),
+			roleID:    uRole,
+			expiredAt: time.Unix(int64(expired), 0).UTC(),
+		}
+		return t, outdated, nil
 	}
-	l += 2
-	lUserID := int(data[l-1])
-	lUserID = max(lUserID, 0)
-	lUserID = min(lUserID, 254)
-	userId = data[l : l+lUserID]
-	l += lUserID
-
-	// expired at
-	expiredAt = 0
-	expiredAt = expiredAt | ((uint64(data[l+0]) << 56) & 0xFF00000000000000)
-	expiredAt = expiredAt | ((uint64(data[l+1]) << 48) & 0x00FF000000000000)
-	expiredAt = expiredAt | ((uint64(data[l+2]) << 40) & 0x0000FF0000000000)
-	expiredAt = expiredAt | ((uint64(data[l+3]) << 32) & 0x000000FF00000000)
-	expiredAt = expiredAt | ((uint64(data[l+4]) << 24) & 0x00000000FF000000)
-	expiredAt = expiredAt | ((uint64(data[l+5]) << 16) & 0x0000000000FF0000)
-	expiredAt = expiredAt | ((uint64(data[l+6]) << 8) & 0x000000000000FF00)
-	expiredAt = expiredAt | (uint64(data[l+7]) & 0x00000000000000FF)
-	l += 8
-
-	// role id
-	userRoleId = 0
-	userRoleId = userRoleId | ((uint64(data[l+0]) << 56) & 0xFF00000000000000)
-	userRoleId = userRoleId | ((uint64(data[l+1]) << 48) & 0x00FF000000000000)
-	userRoleId = userRoleId | ((uint64(data[l+2]) << 40) & 0x0000FF0000000000)
-	userRoleId = userRoleId | ((uint64(data[l+3]) << 32) & 0x000000FF00000000)
-	userRoleId = userRoleId | ((uint64(data[l+4]) << 24) & 0x00000000FF000000)
-	userRoleId = userRoleId | ((uint64(data[l+5]) << 16) & 0x0000000000FF0000)
-	userRoleId = userRoleId | ((uint64(data[l+6]) << 8) & 0x000000000000FF00)
-	userRoleId = userRoleId | (uint64(data[l+7]) & 0x00000000000000FF)
-	l += 8
-
-	// hash check len user id and name
-	if hashLenUserIdAndName, hashCurrent := data[l+8+3], uint8((lUserName^lUserID)&0xFF); hashLenUserIdAndName != hashCurrent {
-		err = fmt.Errorf("incorrect dataset hash")
-		return
+
+	t, err = convertFromByte(internal)
+	if err != nil {
+		return nil, false, asTokenError(ErrMalformed, err)
 	}
 
-	// hash check of userId
-	hashUserID := data[l+8+2]
-	xorUserID := uint8(0)
-	for i := 0; i < lUserID; i++ {
-		xorUserID = xorUserID ^ userId[i]
+	return t, outdated, nil
+}
+
+// convertToByte creates the payload of the v2 format from the token.
+// The user id and user name are prefixed with their lengths, the lengths, role id and expiration time
+// are encoded as varints, the claims section (with the registered claims) follows them.
+func convertToByte(t *token) ([]byte, error) {
+	if l := len(t.userID); l > MaxUserIDLength {
+		return nil, fmt.Errorf("user id is too long, got %d, maximum %d", l, MaxUserIDLength)
 	}
-	if xorUserID != hashUserID {
-		err = fmt.Errorf("incorrect dataset hash")
-		return
+	if l := len(t.userName); l > MaxUserNameLength {
+		return nil, fmt.Errorf("user name is too long, got %d, maximum %d", l, MaxUserNameLength)
 	}
-
-	// hash check of userName
-	hashUserName := data[l+8+1]
-	xorUserName := uint8(0)
-	for i := 0; i < lUserName; i++ {
-		xorUserName = xorUserName ^ userName[i]
+	if err := checkScopes(t.scopes); err != nil {
+		return nil, err
 	}
-	if xorUserName != hashUserName {
-		err = fmt.Errorf("incorrect dataset hash")
-		return
+	for _, tag := range t.claims.Tags() {
+		if tag >= ClaimTagReserved {
+			return nil, fmt.Errorf("claim tag %d is reserved", tag)
+		}
 	}
 
-	// hash check role and expired time
-	if hashRoleIdAndExpiredAt, hashCurrent := data[l+8+0], uint8((expiredAt^userRoleId)&0xFF); hashRoleIdAndExpiredAt != hashCurrent {
-		err = fmt.Errorf("incorrect dataset hash")
-		return
+	claims, err := encodeClaims(withRegisteredClaims(t))
+	if err != nil {
+		return nil, err
 	}
 
-	return
+	b := make([]byte, 0, 4*binary.MaxVarintLen64+len(t.userID)+len(t.userName)+len(claims))
+	b = binary.AppendUvarint(b, uint64(len(t.userID)))
+	b = append(b, t.userID...)
+	b = binary.AppendUvarint(b, uint64(len(t.userName)))
+	b = append(b, t.userName...)
+	b = binary.AppendUvarint(b, t.roleID)
+	b = binary.AppendUvarint(b, uint64(t.expiredAt.UTC().Unix()))
+	b = append(b, claims...)
+
+	return b, nil
 }
 
-// encrypt encrypts the data with the secret key.
-func encrypt(data, secretKey []byte) (b []byte, err error) {
-	defer func() {
-		if e := recover(); e != nil {
-			err = errors.Join(err, fmt.Errorf("panic: %v", e))
+// convertFromByte extracts the token from the payload of the v2 format.
+func convertFromByte(data []byte) (*token, error) {
+	l := 0
+
+	readUvarint := func() (uint64, error) {
+		v, n := binary.Uvarint(data[l:])
+		if n <= 0 {
+			return 0, fmt.Errorf("incorrect dataset")
+		}
+		l += n
+		return v, nil
+	}
+	readBytes := func() ([]byte, error) {
+		n, err := readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(len(data)-l) {
+			return nil, fmt.Errorf("incorrect dataset size")
 		}
-	}()
-
-	// hash crc32
-	hash := crc32.Checksum(data, crc32TableHash)
-
-	var lData = uint64(len(data))
-
-	// combine
-	lDataset := 8 + uint64(len(data)) + 4 + (lData+1)%16
-	dataset := make([]byte, lDataset)
-	var l uint64 = 0
-	dataset[l+0] = uint8((lData & 0xFF00000000000000) >> 56)
-	dataset[l+1] = uint8((lData & 0x00FF000000000000) >> 48)
-	dataset[l+2] = uint8((lData & 0x0000FF0000000000) >> 40)
-	dataset[l+3] = uint8((lData & 0x000000FF00000000) >> 32)
-	dataset[l+4] = uint8((lData & 0x00000000FF000000) >> 24)
-	dataset[l+5] = uint8((lData & 0x0000000000FF0000) >> 16)
-	dataset[l+6] = uint8((lData & 0x000000000000FF00) >> 8)
-	dataset[l+7] = uint8(lData & 0x00000000000000FF)
-	l += 8
-	copy(dataset[l:], data)
-	l += lData
-	dataset[l+3] = uint8((hash & 0xFF000000) >> 24)
-	dataset[l+2] = uint8((hash & 0x00FF0000) >> 16)
-	dataset[l+1] = uint8((hash & 0x0000FF00) >> 8)
-	dataset[l+0] = uint8(hash & 0x000000FF)
-	l += 4
-	for ; l < lDataset; l++ {
-		dataset[l] = uint8(rand.Int31())
-	}
-
-	// aes encryption
-	b, err = aes.Marshal(dataset, secretKey)
+		b := data[l : l+int(n)]
+		l += int(n)
+		return b, nil
+	}
+
+	userID, err := readBytes()
+	if err != nil {
+		return nil, err
+	}
+	userName, err := readBytes()
+	if err != nil {
+		return nil, err
+	}
+	roleID, err := readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	expiredAt, err := readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	claims, err := decodeClaims(data[l:])
 	if err != nil {
 		return nil, err
 	}
 
-	return
+	t := &token{
+		userID:    string(userID),
+		userName:  string(userName),
+		roleID:    roleID,
+		expiredAt: time.Unix(int64(expiredAt), 0).UTC(),
+		claims:    claims,
+	}
+	extractRegisteredClaims(t)
+
+	return t, nil
 }
 
-// decrypt decrypts the data with the secret key.
-func decrypt(data, secretKey []byte) (b []byte, err error) {
-	defer func() {
-		if e := recover(); e != nil {
-			err = errors.Join(err, fmt.Errorf("panic: %v", e))
-		}
-	}()
+// The v2 format (AES-GCM) starts with the header: the version byte, the length of the key id and the key id,
+// the header is authenticated as additional data.
+// The v1 format (AES-CFB + CRC32) has no header and starts directly with a random IV.
+const (
+	tokenVersionLegacy byte = 0x01
+	tokenVersionAEAD   byte = 0x02
+)
 
-	var l uint64 = 0
+// encrypt encrypts and authenticates the data with the active key of the key ring using the v2 format.
+func encrypt(data []byte, ring *KeyRing) ([]byte, error) {
+	keyID, secretKey, err := ring.activeKey()
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, 2+len(keyID))
+	header = append(header, tokenVersionAEAD, uint8(len(keyID)))
+	header = append(header, keyID...)
 
-	// aes decryption
-	var dataset []byte
-	dataset, err = aes.Unmarshal(data, secretKey)
+	sealed, err := aes.Seal(data, header, secretKey)
 	if err != nil {
 		return nil, err
 	}
 
-	var lData uint64 = 0
-	lData = lData | ((uint64(dataset[l+0]) << 56) & 0xFF00000000000000)
-	lData = lData | ((uint64(dataset[l+1]) << 48) & 0x00FF000000000000)
-	lData = lData | ((uint64(dataset[l+2]) << 40) & 0x0000FF0000000000)
-	lData = lData | ((uint64(dataset[l+3]) << 32) & 0x000000FF00000000)
-	lData = lData | ((uint64(dataset[l+4]) << 24) & 0x00000000FF000000)
-	lData = lData | ((uint64(dataset[l+5]) << 16) & 0x0000000000FF0000)
-	lData = lData | ((uint64(dataset[l+6]) << 8) & 0x000000000000FF00)
-	lData = lData | (uint64(dataset[l+7]) & 0x00000000000000FF)
-	l += 8
+	return append(header, sealed...), nil
+}
 
-	if lData > uint64(len(dataset))-l {
-		return nil, fmt.Errorf("incorrect dataset size")
+// decrypt decrypts the data with the key of the key ring referenced by the header,
+// the v1 format is accepted only if the key ring accepts it (see KeyRing.SetAcceptLegacyTokens).
+// The version is the format the data was encrypted in, the outdated flag reports
+// that the data was encrypted with a non-active key or in the v1 format.
+// The returned error is a TokenError with the reason of the rejection.
+func decrypt(data []byte, ring *KeyRing) (b []byte, version byte, outdated bool, err error) {
+	// v1 tokens start with a random IV, so one of 256 of them looks like v2 and has to be retried as v1.
+	if len(data) > 1 && data[0] == tokenVersionAEAD && len(data) >= 2+int(data[1]) {
+		l := 2 + int(data[1])
+		if b, outdated, err = decryptAEAD(data[:l], data[l:], ring); err == nil {
+			return b, tokenVersionAEAD, outdated, nil
+		}
 	}
 
-	// dataset
-	b = dataset[l : l+lData]
-	l += lData
+	if !ring.AcceptsLegacyTokens() {
+		if err == nil {
+			err = newTokenError(ErrUnsupportedVersion, "v1 tokens are not accepted")
+		}
+		return nil, 0, false, err
+	}
 
-	// hash crc32
-	hash := uint32(0)
-	hash = hash | ((uint32(dataset[l+3]) << 24) & 0xFF000000)
-	hash = hash | ((uint32(dataset[l+2]) << 16) & 0x00FF0000)
-	hash = hash | ((uint32(dataset[l+1]) << 8) & 0x0000FF00)
-	hash = hash | (uint32(dataset[l+0]) & 0x000000FF)
-	l += 4
+	// v1 tokens do not reference a key, so every key accepted for verification is tried.
+	var errLegacy error
+	for _, secretKey := range ring.verificationKeys() {
+		b, e := decryptLegacy(data, secretKey)
+		if e == nil {
+			return b, tokenVersionLegacy, true, nil
+		}
+		errLegacy = errors.Join(errLegacy, e)
+	}
 
-	if h := crc32.Checksum(b, crc32TableHash); hash != h {
-		return nil, fmt.Errorf("incorrect hash of data, %X != %X", hash, h)
+	// the error of the v2 format is more specific, if the token looks like v2
+	if err == nil && errLegacy == nil {
+		err = newTokenError(ErrUnknownKey, "key ring has no verification keys")
+	} else if err == nil {
+		err = asTokenError(ErrTampered, errLegacy)
 	}
 
-	return
+	return nil, 0, false, err
 }
 
-var crc32TableHash = crc32.MakeTable(bits.Reverse32(0xF4ACFB10)) // CRM32 for hash of token data
+// decryptAEAD decrypts the v2 payload with the key referenced by the header.
+func decryptAEAD(header, payload []byte, ring *KeyRing) (b []byte, outdated bool, err error) {
+	keyID := string(header[2:])
+
+	secretKey, err := ring.verificationKey(keyID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	b, err = aes.Open(payload, header, secretKey)
+	if err != nil {
+		return nil, false, asTokenError(ErrTampered, err)
+	}
+
+	activeKeyID, ok := ring.ActiveKeyID()
+
+	return b, !ok || activeKeyID != keyID, nil
+}
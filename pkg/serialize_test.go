@@ -2,6 +2,8 @@ package tokeninjector
 
 import (
 	"bytes"
+	"encoding/base64"
+	"errors"
 	"github.com/twinj/uuid"
 	"math/rand"
 	"testing"
@@ -40,17 +42,73 @@ func TestMarshal(t *testing.T) {
 }
 
 func TestConvert(t *testing.T) {
+	expected := &token{
+		userID:    string(bytes.Repeat([]byte{'I'}, 1000)),
+		userName:  string(bytes.Repeat([]byte{'N'}, 300)),
+		roleID:    rand.Uint64(),
+		expiredAt: time.Unix(rand.Int63n(1<<40), 0).UTC(),
+		tokenID:   uuid.NewV4().String(),
+	}
+
+	dataset, err := convertToByte(expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := convertFromByte(dataset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e, a := expected.userID, actual.userID; e != a {
+		t.Errorf("incorrect token userId, got %d bytes, expected %d", len(a), len(e))
+	}
+	if e, a := expected.userName, actual.userName; e != a {
+		t.Errorf("incorrect token userName, got %d bytes, expected %d", len(a), len(e))
+	}
+	if e, a := expected.roleID, actual.roleID; e != a {
+		t.Errorf("incorrect token userRoleId, got %d, expected %d", a, e)
+	}
+	if e, a := expected.expiredAt, actual.expiredAt; !e.Equal(a) {
+		t.Errorf("incorrect token expiredAt, got %s, expected %s", a, e)
+	}
+	if e, a := expected.tokenID, actual.tokenID; e != a {
+		t.Errorf("incorrect token tokenID, got %s, expected %s", a, e)
+	}
+
+	for l := 0; l < len(dataset)-1; l += 97 {
+		if _, err = convertFromByte(dataset[:l]); err == nil {
+			t.Fatalf("truncated dataset of %d bytes was accepted", l)
+		}
+	}
+}
+
+func TestMarshal_MaxLength(t *testing.T) {
+	secretKey := uuid.NewV4().Bytes()
+
+	if _, err := Marshal(string(bytes.Repeat([]byte{'I'}, MaxUserIDLength+1)), "name", 0, time.Now(), secretKey); err == nil {
+		t.Errorf("too long user id was accepted")
+	}
+	if _, err := Marshal("id", string(bytes.Repeat([]byte{'N'}, MaxUserNameLength+1)), 0, time.Now(), secretKey); err == nil {
+		t.Errorf("too long user name was accepted")
+	}
+	if _, err := Marshal(string(bytes.Repeat([]byte{'I'}, MaxUserIDLength)), "name", 0, time.Now(), secretKey); err != nil {
+		t.Errorf("user id of the maximum length was rejected; details: %s", err.Error())
+	}
+}
+
+func TestConvertLegacy(t *testing.T) {
 	expectedUserId := bytes.Repeat([]byte{'I'}, 100)
 	expectedUserName := bytes.Repeat([]byte{'N'}, 254)
 	expectedRoleId := rand.Uint64()
 	expectedExpiredAt := rand.Uint64()
 
-	dataset := convertToByte(expectedUserId, expectedUserName, expectedRoleId, expectedExpiredAt)
+	dataset := convertToByteLegacy(expectedUserId, expectedUserName, expectedRoleId, expectedExpiredAt)
 	if l := len(expectedUserId) + len(expectedUserName) + 40; len(dataset) != l {
 		t.Fatalf("incoorect token dataset, got %d, expected %d", len(dataset), l)
 	}
 
-	actualUserId, actualUserName, actualRoleId, actualExpiredAt, err := convertFromByte(dataset)
+	actualUserId, actualUserName, actualRoleId, actualExpiredAt, err := convertFromByteLegacy(dataset)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -74,9 +132,12 @@ func TestConvert(t *testing.T) {
 
 func TestCrypt(t *testing.T) {
 	expectedDataset := bytes.Repeat([]byte{'x'}, 52)
-	secretKey := uuid.NewV4().Bytes()
+	ring, err := newSingleKeyRing(uuid.NewV4().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	d, err := encrypt(expectedDataset, secretKey)
+	d, err := encrypt(expectedDataset, ring)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -87,7 +148,7 @@ func TestCrypt(t *testing.T) {
 		t.Fatalf("incoorect external token")
 	}
 
-	actualDataset, err := decrypt(d, secretKey)
+	actualDataset, _, _, err := decrypt(d, ring)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -98,3 +159,132 @@ func TestCrypt(t *testing.T) {
 		t.Fatalf("incoorect internal token")
 	}
 }
+
+func TestCrypt_Tampered(t *testing.T) {
+	expectedDataset := bytes.Repeat([]byte{'x'}, 52)
+	ring, err := newSingleKeyRing(uuid.NewV4().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := encrypt(expectedDataset, ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d[0] != tokenVersionAEAD {
+		t.Fatalf("incorrect token version, got %d, expected %d", d[0], tokenVersionAEAD)
+	}
+
+	for i := range d {
+		tampered := bytes.Clone(d)
+		tampered[i] ^= 0x80
+		if _, _, _, err = decrypt(tampered, ring); err == nil {
+			t.Fatalf("tampered byte %d was not detected", i)
+		}
+	}
+}
+
+func TestCrypt_Legacy(t *testing.T) {
+	expectedDataset := bytes.Repeat([]byte{'x'}, 52)
+	secretKey := uuid.NewV4().Bytes()
+
+	ring, err := newSingleKeyRing(secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := encryptLegacy(expectedDataset, secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actualDataset, _, _, err := decrypt(d, ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(actualDataset, expectedDataset) != 0 {
+		t.Fatalf("incorrect internal token")
+	}
+
+	ring.SetAcceptLegacyTokens(false)
+	if _, _, _, err = decrypt(d, ring); err == nil {
+		t.Fatalf("legacy token was accepted")
+	}
+}
+
+func FuzzUnmarshal(f *testing.F) {
+	secretKey := uuid.NewV4().Bytes()
+	ring, err := newSingleKeyRing(secretKey)
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	dataset, err := MarshalWithKeyRing(uuid.NewV4().String(), uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Hour), ring, WithStringClaim(1, "claim"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	legacy, err := encryptLegacy(convertToByteLegacy([]byte("id"), []byte("name"), 1, 2), secretKey)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(dataset)
+	f.Add(base64.StdEncoding.EncodeToString(legacy))
+	f.Add(base64.StdEncoding.EncodeToString([]byte{tokenVersionAEAD, 0xFF}))
+	f.Add("")
+
+	f.Fuzz(func(t *testing.T, data string) {
+		if _, err := UnmarshalToken(data, ring); err != nil && !errors.As(err, new(*TokenError)) {
+			t.Errorf("error is not a TokenError, got %#v", err)
+		}
+		_, _, _, _, _ = Unmarshal(data, secretKey)
+	})
+}
+
+func FuzzConvertFromByte(f *testing.F) {
+	dataset, err := convertToByte(&token{userID: "id", userName: "name", roleID: 1, tokenID: "jti"})
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(dataset)
+	f.Add(convertToByteLegacy([]byte("id"), []byte("name"), 1, 2))
+	f.Add([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x01})
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if tkn, err := convertFromByte(data); err == nil {
+			if b, err := convertToByte(tkn); err == nil {
+				if _, err = convertFromByte(b); err != nil {
+					t.Errorf("encoded token could not be decoded; details: %s", err.Error())
+				}
+			}
+		}
+		_, _, _, _, _ = convertFromByteLegacy(data)
+	})
+}
+
+func TestUnmarshal_Validation(t *testing.T) {
+	secretKey := uuid.NewV4().Bytes()
+	ring, err := newSingleKeyRing(secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := MarshalWithKeyRing(uuid.NewV4().String(), "name", 0, time.Now().Add(-time.Minute), ring, WithAudience("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, _, err = Unmarshal(expired, secretKey, WithExpectedAudience("a")); err != nil {
+		t.Errorf("expired token was rejected; details: %s", err.Error())
+	}
+	if _, _, _, _, err = Unmarshal(expired, secretKey, WithExpectedAudience("b")); !errors.Is(err, ErrInvalidClaims) {
+		t.Errorf("incorrect error for another audience, got %v, expected %v", err, ErrInvalidClaims)
+	}
+
+	old, err := MarshalWithKeyRing(uuid.NewV4().String(), "name", 0, time.Now().Add(time.Hour), ring, WithIssuedAt(time.Now().Add(-time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, _, err = UnmarshalWithKeyRing(old, ring, WithMaxAge(time.Minute)); !errors.Is(err, ErrExpired) {
+		t.Errorf("incorrect error for the token older than max age, got %v, expected %v", err, ErrExpired)
+	}
+}
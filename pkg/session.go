@@ -0,0 +1,370 @@
+package tokeninjector
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// opaqueIDSize is the number of random bytes of the session ids and refresh tokens.
+const opaqueIDSize = 32
+
+// newOpaqueID creates a random id encoded in base64 (URL alphabet, no padding), it is safe in cookies and forms.
+func newOpaqueID() (string, error) {
+	b := make([]byte, opaqueIDSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashSessionID returns the hash of the session id, which is the key of the session in the stores,
+// so the session ids are not disclosed by the memory or the file of the store.
+func hashSessionID(sessionID string) string {
+	h := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(h[:])
+}
+
+// SessionStore keeps the tokens of the sessions on the server, the cookie carries only the random session id,
+// see SessionHandler. The session can be deleted at any time, so it is revoked instantly.
+type SessionStore interface {
+	// Save stores the token of the session, the record may be removed after the token expires.
+	Save(sessionID string, t Token) error
+	// Load returns the token of the session, or nil if the session is unknown or expired.
+	Load(sessionID string) (Token, error)
+	// Delete removes the session.
+	Delete(sessionID string) error
+}
+
+// CreateSession stores the token in the store under a new random session id, e.g. on login.
+// The session id is the value of the cookie or bearer credential, see also CookieOptions.Sessions.
+func CreateSession(store SessionStore, t Token) (string, error) {
+	if store == nil {
+		return "", errors.New("session store is not defined")
+	}
+	if t == nil {
+		return "", errors.New("token is not defined")
+	}
+	if !time.Now().Before(t.ExpiredAt()) {
+		return "", newTokenError(ErrExpired, "expired at %s", t.ExpiredAt())
+	}
+	sessionID, err := newOpaqueID()
+	if err != nil {
+		return "", err
+	}
+	if err = store.Save(sessionID, t); err != nil {
+		return "", err
+	}
+	return sessionID, nil
+}
+
+// DeleteSession removes the session of the cookie set by IssueCookie from the store and clears the cookie, e.g. on logout.
+func DeleteSession(w http.ResponseWriter, r *http.Request, store SessionStore, opts CookieOptions) error {
+	if store == nil {
+		return errors.New("session store is not defined")
+	}
+	if c, err := r.Cookie(opts.Name); err == nil && len(c.Value) > 0 {
+		if err = store.Delete(strings.TrimSpace(c.Value)); err != nil {
+			return err
+		}
+	}
+	return ClearCookie(w, opts)
+}
+
+// SessionHandler is a middleware like TokenHandler, but the cookie (or the bearer credential with WithBearerToken)
+// carries the session id, which is resolved to the token by the store. The handlers get the token by ExtractToken.
+// The cookie reissue and the sliding expiration are not supported, the session is managed by the store.
+//   - store: the store of the sessions, see CreateSession.
+//
+// IMPORTANT: does not return an error if the user ID is not found, unless WithRequireToken is set.
+func SessionHandler(
+	store SessionStore,
+	cookieName string,
+	nextFunc http.HandlerFunc,
+	opts ...HandlerOption,
+) (http.HandlerFunc, error) {
+	if store == nil {
+		return nil, errors.New("session store is not defined")
+	}
+	codec := tokenCodec{
+		decode: func(data string) (*token, bool, error) {
+			t, err := loadSession(store, data)
+			return t, false, err
+		},
+	}
+	return newTokenHandler(codec, cookieName, nextFunc, opts)
+}
+
+// loadSession returns the token of the session, the unknown session is rejected with ErrUnknownSession
+// and the failure of the store with ErrUnavailable.
+func loadSession(store SessionStore, sessionID string) (*token, error) {
+	t, err := store.Load(sessionID)
+	if err != nil {
+		return nil, newTokenError(ErrUnavailable, "could not load the session; details: %s", err.Error())
+	}
+	if t == nil {
+		return nil, newTokenError(ErrUnknownSession, "session is not found")
+	}
+	return tokenOf(t), nil
+}
+
+// MemorySessionStore is the SessionStore in memory, the sessions are removed when the tokens expire.
+// The sessions are kept under the hash of the session id.
+type MemorySessionStore struct {
+	mutex    sync.RWMutex
+	sessions map[string]Token
+	swept    time.Time
+}
+
+// NewMemorySessionStore creates an empty MemorySessionStore.
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]Token), swept: time.Now()}
+}
+
+// Save stores the token of the session.
+func (s *MemorySessionStore) Save(sessionID string, t Token) error {
+	if t == nil {
+		return errors.New("token is not defined")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if now := time.Now(); now.Sub(s.swept) >= sweepInterval {
+		s.sweep(now)
+	}
+	s.sessions[hashSessionID(sessionID)] = t
+
+	return nil
+}
+
+// Load returns the token of the session, or nil if the session is unknown or expired.
+func (s *MemorySessionStore) Load(sessionID string) (Token, error) {
+	s.mutex.RLock()
+	t, ok := s.sessions[hashSessionID(sessionID)]
+	s.mutex.RUnlock()
+
+	if !ok || !time.Now().Before(t.ExpiredAt()) {
+		return nil, nil
+	}
+	return t, nil
+}
+
+// Delete removes the session.
+func (s *MemorySessionStore) Delete(sessionID string) error {
+	s.mutex.Lock()
+	delete(s.sessions, hashSessionID(sessionID))
+	s.mutex.Unlock()
+
+	return nil
+}
+
+// sweep removes the sessions of the expired tokens, the caller holds the lock.
+func (s *MemorySessionStore) sweep(now time.Time) {
+	for hash, t := range s.sessions {
+		if !now.Before(t.ExpiredAt()) {
+			delete(s.sessions, hash)
+		}
+	}
+	s.swept = now
+}
+
+// FileSessionStore is the SessionStore backed by the file, so the sessions survive restarts and are shared
+// by the processes on the same host. Every change is appended to the file as the line
+// "<expiration unix time> <session id hash> <token>" (the hex SHA-256 of the session id and the token payload
+// in base64), the deletion has no token.
+// The file is reloaded when another process changes it, the expired and deleted sessions are kept in it until Compact.
+type FileSessionStore struct {
+	path    string
+	mutex   sync.Mutex
+	memory  *MemorySessionStore
+	modTime time.Time
+	size    int64
+	checked time.Time
+}
+
+// NewFileSessionStore creates the store of the file, the file is created if it does not exist.
+func NewFileSessionStore(path string) (*FileSessionStore, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err = f.Close(); err != nil {
+		return nil, err
+	}
+	s := &FileSessionStore{path: path, memory: NewMemorySessionStore()}
+	if err = s.reload(time.Now()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Save appends the session to the file and syncs it.
+func (s *FileSessionStore) Save(sessionID string, t Token) error {
+	if t == nil {
+		return errors.New("token is not defined")
+	}
+	payload, err := convertToByte(tokenOf(t))
+	if err != nil {
+		return err
+	}
+	line := fmt.Sprintf("%d %s %s\n", t.ExpiredAt().Unix(), hashSessionID(sessionID), base64.StdEncoding.EncodeToString(payload))
+	return s.append(sessionID, line, func() error { return s.memory.Save(sessionID, t) })
+}
+
+// Load returns the token of the session, the file is reloaded if it was changed.
+func (s *FileSessionStore) Load(sessionID string) (Token, error) {
+	s.mutex.Lock()
+	if now := time.Now(); now.Sub(s.checked) >= fileCheckInterval {
+		if err := s.reload(now); err != nil {
+			s.mutex.Unlock()
+			return nil, err
+		}
+	}
+	s.mutex.Unlock()
+
+	return s.memory.Load(sessionID)
+}
+
+// Delete appends the deletion of the session to the file and syncs it.
+func (s *FileSessionStore) Delete(sessionID string) error {
+	line := fmt.Sprintf("0 %s\n", hashSessionID(sessionID))
+	return s.append(sessionID, line, func() error { return s.memory.Delete(sessionID) })
+}
+
+// Compact rewrites the file without the expired and deleted sessions.
+// The file is replaced, so the sessions appended by other processes during Compact may be lost.
+func (s *FileSessionStore) Compact() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.reload(time.Now()); err != nil {
+		return err
+	}
+
+	s.memory.mutex.Lock()
+	s.memory.sweep(time.Now())
+	var b bytes.Buffer
+	for hash, t := range s.memory.sessions {
+		payload, err := convertToByte(tokenOf(t))
+		if err != nil {
+			s.memory.mutex.Unlock()
+			return err
+		}
+		_, _ = fmt.Fprintf(&b, "%d %s %s\n", t.ExpiredAt().Unix(), hash, base64.StdEncoding.EncodeToString(payload))
+	}
+	s.memory.mutex.Unlock()
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b.Bytes(), 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.checked = time.Time{}
+
+	return nil
+}
+
+// append appends the line to the file, syncs it and then applies the change to the memory.
+func (s *FileSessionStore) append(sessionID string, line string, apply func() error) error {
+	if len(sessionID) == 0 || strings.ContainsAny(sessionID, " \r\n") {
+		return fmt.Errorf("session id %q can not be stored", sessionID)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(line)
+	if err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return err
+	}
+
+	return apply()
+}
+
+// reload loads the file if it was modified since the last load, the caller holds the lock.
+func (s *FileSessionStore) reload(now time.Time) error {
+	s.checked = now
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	sessions, err := parseSessions(data)
+	if err != nil {
+		return fmt.Errorf("could not parse %s; details: %s", s.path, err.Error())
+	}
+
+	s.memory.mutex.Lock()
+	s.memory.sessions = sessions
+	s.memory.sweep(now)
+	s.memory.mutex.Unlock()
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+
+	return nil
+}
+
+// parseSessions parses the lines of the session file into the sessions by the hash of the session id,
+// the later lines override the earlier ones.
+// The last line without the line break is skipped, it is being written by another process.
+func parseSessions(data []byte) (map[string]Token, error) {
+	sessions := make(map[string]Token)
+	lines := strings.Split(string(data), "\n")
+	for n, line := range lines[:len(lines)-1] {
+		if len(line) == 0 {
+			continue
+		}
+		fields := strings.Split(line, " ")
+		if len(fields) < 2 || len(fields) > 3 || len(fields[1]) == 0 {
+			return nil, fmt.Errorf("incorrect line %d", n+1)
+		}
+		if _, err := strconv.ParseInt(fields[0], 10, 64); err != nil {
+			return nil, fmt.Errorf("incorrect line %d; details: %s", n+1, err.Error())
+		}
+		if len(fields) == 2 {
+			delete(sessions, fields[1])
+			continue
+		}
+		payload, err := base64.StdEncoding.DecodeString(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("incorrect line %d; details: %s", n+1, err.Error())
+		}
+		t, err := convertFromByte(payload)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect line %d; details: %s", n+1, err.Error())
+		}
+		sessions[fields[1]] = t
+	}
+	return sessions, nil
+}
@@ -0,0 +1,224 @@
+package tokeninjector
+
+import (
+	"errors"
+	"github.com/prorochestvo/tokeninjector/internal"
+	"github.com/twinj/uuid"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemorySessionStore(t *testing.T) {
+	s := NewMemorySessionStore()
+	active := NewToken(uuid.NewV4().String(), uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Hour))
+	expired := NewToken(uuid.NewV4().String(), uuid.NewV4().String(), rand.Uint64(), time.Now().Add(-time.Second))
+
+	if err := s.Save("active", active); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save("expired", expired); err != nil {
+		t.Fatal(err)
+	}
+
+	if tkn, err := s.Load("active"); err != nil || tkn == nil || tkn.UserID() != active.UserID() {
+		t.Errorf("incorrect active session, got %v, %v", tkn, err)
+	}
+	if tkn, err := s.Load("expired"); err != nil || tkn != nil {
+		t.Errorf("incorrect expired session, got %v, %v", tkn, err)
+	}
+	if err := s.Delete("active"); err != nil {
+		t.Fatal(err)
+	}
+	if tkn, err := s.Load("active"); err != nil || tkn != nil {
+		t.Errorf("incorrect deleted session, got %v, %v", tkn, err)
+	}
+
+	// the expired sessions are evicted by the sweep
+	s.swept = time.Time{}
+	if err := s.Save("other", active); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.sessions[hashSessionID("expired")]; ok || len(s.sessions) != 1 {
+		t.Errorf("incorrect sweep, got %d sessions", len(s.sessions))
+	}
+}
+
+func TestFileSessionStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions")
+	s, err := NewFileSessionStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scopes := []string{"orders:read"}
+	active := NewToken(uuid.NewV4().String(), uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Hour), WithScopes(scopes...))
+	expired := NewToken(uuid.NewV4().String(), uuid.NewV4().String(), rand.Uint64(), time.Now().Add(-time.Second))
+
+	activeID, err := CreateSession(s, active)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Save("expired", expired); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Save("deleted", active); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Delete("deleted"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = CreateSession(s, expired); !errors.Is(err, ErrExpired) {
+		t.Errorf("expired token was stored, got %v", err)
+	}
+	if err = s.Save("with space", active); err == nil {
+		t.Errorf("session id with space was stored")
+	}
+
+	// another process sees the sessions of the file
+	other, err := NewFileSessionStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tkn, err := other.Load(activeID)
+	if err != nil || tkn == nil {
+		t.Fatalf("incorrect active session, got %v, %v", tkn, err)
+	}
+	if tkn.UserID() != active.UserID() || tkn.UserRoleID() != active.UserRoleID() || !slices.Equal(tkn.Scopes(), scopes) {
+		t.Errorf("incorrect token of the session, got %s %d %v", tkn.UserID(), tkn.UserRoleID(), tkn.Scopes())
+	}
+	if tkn, err = other.Load("deleted"); err != nil || tkn != nil {
+		t.Errorf("incorrect deleted session, got %v, %v", tkn, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), activeID) || strings.Contains(string(data), "deleted") {
+		t.Errorf("session id is stored in the file, got %q", data)
+	}
+
+	if err = s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if data, err = os.ReadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 1 || !strings.Contains(string(data), hashSessionID(activeID)) {
+		t.Errorf("incorrect compacted file, got %q", data)
+	}
+	if strings.Contains(string(data), activeID) {
+		t.Errorf("session id is stored in the file, got %q", data)
+	}
+}
+
+func TestSessionHandler(t *testing.T) {
+	store := NewMemorySessionStore()
+	cookieName := uuid.NewV4().String()
+	userID := uuid.NewV4().String()
+
+	h, err := SessionHandler(store, cookieName, func(w http.ResponseWriter, r *http.Request) {
+		v, err := ExtractToken(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(v.UserID()))
+	}, WithBearerToken())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := httptest.NewRecorder()
+	err = IssueCookie(res, NewToken(userID, uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Hour)), CookieOptions{Name: cookieName, Sessions: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := res.Result().Cookies()[0]
+	if len(cookie.Value) == 0 || strings.Contains(cookie.Value, userID) {
+		t.Fatalf("incorrect session cookie, got %s", cookie.Value)
+	}
+
+	res = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+	h(res, req)
+	if res.Code != http.StatusOK || res.Body.String() != userID {
+		t.Errorf("incorrect response of the session cookie, got %d %s", res.Code, res.Body.String())
+	}
+
+	res = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(internal.HeaderAuthorization, internal.AuthMethodBearer+" "+cookie.Value)
+	h(res, req)
+	if res.Code != http.StatusOK || res.Body.String() != userID {
+		t.Errorf("incorrect response of the session bearer, got %d %s", res.Code, res.Body.String())
+	}
+
+	// the deleted session is rejected instantly
+	res = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/logout", nil)
+	req.AddCookie(cookie)
+	if err = DeleteSession(res, req, store, CookieOptions{Name: cookieName}); err != nil {
+		t.Fatal(err)
+	}
+	if c := res.Result().Cookies(); len(c) != 1 || c[0].MaxAge >= 0 {
+		t.Errorf("incorrect cleared cookie, got %v", c)
+	}
+
+	res = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+	h(res, req)
+	if res.Code != http.StatusUnauthorized || !strings.Contains(res.Body.String(), ErrUnknownSession.Error()) {
+		t.Errorf("incorrect response of the deleted session, got %d %s", res.Code, res.Body.String())
+	}
+
+	if _, err = SessionHandler(store, cookieName, nil, WithSlidingExpiration(time.Minute, time.Hour, 0)); err == nil {
+		t.Errorf("sliding expiration was accepted")
+	}
+}
+
+func TestSessionHandler_StoreFailure(t *testing.T) {
+	store := &failingSessionStore{MemorySessionStore: NewMemorySessionStore(), err: errors.New("could not read /var/lib/secret/sessions")}
+	cookieName := uuid.NewV4().String()
+
+	var tokenErr error
+	h, err := SessionHandler(store, cookieName, func(w http.ResponseWriter, r *http.Request) {
+		_, tokenErr = ExtractToken(r.Context())
+	}, WithRequireToken())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "application/json")
+	req.AddCookie(&http.Cookie{Name: cookieName, Value: uuid.NewV4().String()})
+	h(res, req)
+	if s := res.Body.String(); res.Code != http.StatusServiceUnavailable || strings.Contains(s, "/var/lib") || !strings.Contains(s, "temporarily_unavailable") {
+		t.Errorf("incorrect response of the failed store, got %d %s", res.Code, s)
+	}
+	if tokenErr != nil {
+		t.Errorf("next handler was called, got %v", tokenErr)
+	}
+
+	if _, err = loadSession(store, uuid.NewV4().String()); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("incorrect error of the failed store, got %v", err)
+	}
+}
+
+// failingSessionStore is the MemorySessionStore that fails to load the sessions.
+type failingSessionStore struct {
+	*MemorySessionStore
+	err error
+}
+
+func (s *failingSessionStore) Load(string) (Token, error) { return nil, s.err }
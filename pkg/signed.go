@@ -0,0 +1,120 @@
+package tokeninjector
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// tokenVersionSigned is the leading byte of the signed format (Ed25519).
+// The payload of the signed format is not encrypted, it is followed by the signature of the version byte and payload.
+const tokenVersionSigned byte = 0x03
+
+// MarshalSigned creates a token string like Marshal, but the token is signed with the Ed25519 private key
+// instead of being encrypted. The claims of the token are readable by anyone, only their authenticity is protected.
+func MarshalSigned(userID string, userName string, roleID uint64, expiredAt time.Time, privateKey ed25519.PrivateKey, opts ...MarshalOption) (string, error) {
+	return marshalSignedToken(newToken(userID, userName, roleID, expiredAt, opts), privateKey)
+}
+
+// UnmarshalSigned extracts the token from the token string created by MarshalSigned and verifies its signature
+// with the Ed25519 public key. Like UnmarshalToken, it rejects expired tokens and performs the checks set by the options.
+func UnmarshalSigned(data string, publicKey crypto.PublicKey, opts ...ValidateOption) (Token, error) {
+	key, err := ed25519PublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	t, err := unmarshalSignedToken(data, key)
+	if err != nil {
+		return nil, err
+	}
+	if err = validateToken(t, newValidateOptions(opts)); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// PublicKeyHandler is a middleware like TokenHandler, but the token is signed by MarshalSigned and verified
+// with the public key, so the service does not hold a key that can issue tokens.
+//   - publicKey: the Ed25519 public key (ed25519.PublicKey) used to verify the token.
+//
+// IMPORTANT: does not return an error if the user ID is not found, unless WithRequireToken is set.
+func PublicKeyHandler(
+	publicKey crypto.PublicKey,
+	cookieName string,
+	nextFunc http.HandlerFunc,
+	opts ...HandlerOption,
+) (http.HandlerFunc, error) {
+	key, err := ed25519PublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	codec := tokenCodec{
+		decode: func(data string) (*token, bool, error) {
+			t, err := unmarshalSignedToken(data, key)
+			return t, false, err
+		},
+	}
+	return newTokenHandler(codec, cookieName, nextFunc, opts)
+}
+
+// ed25519PublicKey checks that the public key is an Ed25519 key.
+func ed25519PublicKey(publicKey crypto.PublicKey) (ed25519.PublicKey, error) {
+	key, ok := publicKey.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported public key %T, expected ed25519.PublicKey", publicKey)
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key size %d, expected %d", len(key), ed25519.PublicKeySize)
+	}
+	return key, nil
+}
+
+// marshalSignedToken creates a token string from the token signed with the private key.
+func marshalSignedToken(t *token, privateKey ed25519.PrivateKey) (string, error) {
+	if len(privateKey) != ed25519.PrivateKeySize {
+		return "", errors.New("invalid private key")
+	}
+
+	payload, err := convertToByte(t)
+	if err != nil {
+		return "", err
+	}
+
+	dataset := make([]byte, 0, 1+len(payload)+ed25519.SignatureSize)
+	dataset = append(dataset, tokenVersionSigned)
+	dataset = append(dataset, payload...)
+	dataset = append(dataset, ed25519.Sign(privateKey, dataset)...)
+
+	return base64.StdEncoding.EncodeToString(dataset), nil
+}
+
+// unmarshalSignedToken extracts the token from the token string and verifies its signature with the public key.
+func unmarshalSignedToken(data string, publicKey ed25519.PublicKey) (*token, error) {
+	dataset, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, asTokenError(ErrMalformed, err)
+	}
+
+	if len(dataset) == 0 || dataset[0] != tokenVersionSigned {
+		return nil, newTokenError(ErrUnsupportedVersion, "token is not signed")
+	}
+	if len(dataset) < 1+ed25519.SignatureSize {
+		return nil, newTokenError(ErrMalformed, "incorrect dataset size")
+	}
+
+	l := len(dataset) - ed25519.SignatureSize
+	if !ed25519.Verify(publicKey, dataset[:l], dataset[l:]) {
+		return nil, newTokenError(ErrTampered, "incorrect signature")
+	}
+
+	t, err := convertFromByte(dataset[1:l])
+	if err != nil {
+		return nil, asTokenError(ErrMalformed, err)
+	}
+
+	return t, nil
+}
@@ -0,0 +1,110 @@
+package tokeninjector
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"github.com/twinj/uuid"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMarshalSigned(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedUserID := uuid.NewV4().String()
+	expectedAudience := uuid.NewV4().String()
+
+	dataset, err := MarshalSigned(expectedUserID, uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Hour), privateKey, WithAudience(expectedAudience))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tkn, err := UnmarshalSigned(dataset, publicKey, WithExpectedAudience(expectedAudience))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := tkn.UserID(); a != expectedUserID {
+		t.Errorf("incorrect token userId, got %s, expected %s", a, expectedUserID)
+	}
+
+	otherPublicKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = UnmarshalSigned(dataset, otherPublicKey); !errors.Is(err, ErrTampered) {
+		t.Errorf("token of another key was not rejected, got %v", err)
+	}
+
+	tampered, err := base64.StdEncoding.DecodeString(dataset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered[3] ^= 0x01
+	if _, err = UnmarshalSigned(base64.StdEncoding.EncodeToString(tampered), publicKey); !errors.Is(err, ErrTampered) {
+		t.Errorf("tampered token was not rejected, got %v", err)
+	}
+
+	encrypted, err := Marshal(expectedUserID, "name", 0, time.Now().Add(time.Hour), uuid.NewV4().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = UnmarshalSigned(encrypted, publicKey); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("encrypted token was not rejected, got %v", err)
+	}
+
+	if _, err = UnmarshalSigned(dataset, []byte(publicKey)); err == nil {
+		t.Errorf("public key of unsupported type was accepted")
+	}
+}
+
+func TestPublicKeyHandler(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID := uuid.NewV4().String()
+
+	cookieName := uuid.NewV4().String()
+	cookieValue, err := MarshalSigned(userID, uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Hour), privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: cookieName, Value: cookieValue, HttpOnly: true})
+
+	h, err := PublicKeyHandler(publicKey, cookieName, func(w http.ResponseWriter, r *http.Request) {
+		v, err := ExtractToken(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(v.UserID()))
+	})
+	if err != nil {
+		t.Fatalf("could not create nextHandler; details: %s", err.Error())
+	}
+
+	h(res, req)
+	if res.Code != http.StatusOK {
+		t.Errorf("incorrect response code, got %d", res.Code)
+	}
+	if s := res.Body.String(); s != userID {
+		t.Errorf("incorrect response body, got %s", s)
+	}
+
+	if _, err = PublicKeyHandler(publicKey, cookieName, nil, WithCookieReissue(http.Cookie{})); err == nil {
+		t.Errorf("verify-only middleware accepted cookie reissue")
+	}
+	if _, err = PublicKeyHandler(privateKey, cookieName, nil); err == nil {
+		t.Errorf("private key was accepted as a public key")
+	}
+}
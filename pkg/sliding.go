@@ -0,0 +1,60 @@
+package tokeninjector
+
+import (
+	"fmt"
+	"time"
+)
+
+// slidingExpiration is the setting of WithSlidingExpiration.
+type slidingExpiration struct {
+	window      time.Duration
+	lifetime    time.Duration
+	maxLifetime time.Duration
+}
+
+// check checks that the durations are consistent, nil setting is correct.
+func (s *slidingExpiration) check() error {
+	switch {
+	case s == nil:
+		return nil
+	case s.window <= 0 || s.lifetime <= 0:
+		return fmt.Errorf("sliding expiration window and lifetime must be positive")
+	case s.window > s.lifetime:
+		return fmt.Errorf("sliding expiration window %s is longer than lifetime %s", s.window, s.lifetime)
+	case s.maxLifetime < s.lifetime:
+		return fmt.Errorf("sliding expiration maximum lifetime %s is shorter than lifetime %s", s.maxLifetime, s.lifetime)
+	}
+	return nil
+}
+
+// limit sets the end of the maximum lifetime of the session to the token, so RevokeToken keeps the revocation
+// until the renewed copies of the token expire.
+func (s *slidingExpiration) limit(t *token) {
+	if s == nil || t == nil || t.issuedAt.IsZero() {
+		return
+	}
+	t.sessionExpiredAt = t.issuedAt.Add(s.maxLifetime)
+}
+
+// renew returns the copy of the token with the new expiration if the token expires within the window,
+// otherwise nil, e.g. if the token has reached the maximum lifetime.
+func (s *slidingExpiration) renew(t *token, now time.Time) *token {
+	if s == nil || t == nil || t.issuedAt.IsZero() || t.expiredAt.Sub(now) > s.window {
+		return nil
+	}
+
+	expiredAt := now.Add(s.lifetime)
+	if limit := t.issuedAt.Add(s.maxLifetime); expiredAt.After(limit) {
+		expiredAt = limit
+	}
+	// the expiration is stored in seconds
+	expiredAt = expiredAt.Truncate(time.Second)
+	if !expiredAt.After(t.expiredAt) {
+		return nil
+	}
+
+	renewed := *t
+	renewed.expiredAt = expiredAt.UTC()
+
+	return &renewed
+}
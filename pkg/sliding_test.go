@@ -0,0 +1,140 @@
+package tokeninjector
+
+import (
+	"github.com/twinj/uuid"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenHandler_SlidingExpiration(t *testing.T) {
+	secretKey := uuid.NewV4().Bytes()
+	ring, err := newSingleKeyRing(secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookieName := uuid.NewV4().String()
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		issuedAt  time.Time
+		expiredAt time.Time
+		renewed   time.Time
+	}{
+		{"within window", now.Add(-10 * time.Minute), now.Add(time.Minute), now.Add(30 * time.Minute)},
+		{"maximum lifetime", now.Add(-50 * time.Minute), now.Add(time.Minute), now.Add(10 * time.Minute)},
+		{"out of window", now.Add(-10 * time.Minute), now.Add(20 * time.Minute), time.Time{}},
+		{"maximum lifetime reached", now.Add(-59*time.Minute - 30*time.Second), now.Add(30 * time.Second), time.Time{}},
+		{"no issue time", time.Time{}, now.Add(time.Minute), time.Time{}},
+	}
+	for _, tt := range tests {
+		tokenID := uuid.NewV4().String()
+		cookieValue, err := Marshal(uuid.NewV4().String(), uuid.NewV4().String(), rand.Uint64(), tt.expiredAt, secretKey, WithIssuedAt(tt.issuedAt), WithTokenID(tokenID))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var expiredAt time.Time
+		h, err := TokenHandler(secretKey, cookieName, func(w http.ResponseWriter, r *http.Request) {
+			if tkn, err := ExtractToken(r.Context()); err == nil {
+				expiredAt = tkn.ExpiredAt()
+			}
+		}, WithSlidingExpiration(5*time.Minute, 30*time.Minute, time.Hour))
+		if err != nil {
+			t.Fatalf("could not create nextHandler; details: %s", err.Error())
+		}
+
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: cookieName, Value: cookieValue, HttpOnly: true})
+
+		h(res, req)
+		cookies := res.Result().Cookies()
+		if tt.renewed.IsZero() {
+			if len(cookies) != 0 {
+				t.Errorf("%s: incorrect renewal, got %v", tt.name, cookies)
+			}
+			if expiredAt.Unix() != tt.expiredAt.Unix() {
+				t.Errorf("%s: incorrect expiration, got %s", tt.name, expiredAt)
+			}
+			continue
+		}
+		if len(cookies) != 1 {
+			t.Fatalf("%s: incorrect number of cookies, got %d", tt.name, len(cookies))
+		}
+		if d := expiredAt.Sub(tt.renewed); d < -2*time.Second || d > time.Second {
+			t.Errorf("%s: incorrect expiration, got %s, expected %s", tt.name, expiredAt, tt.renewed)
+		}
+		if c := cookies[0]; c.Name != cookieName || !c.Secure || !c.HttpOnly {
+			t.Errorf("%s: incorrect cookie attributes, got %s", tt.name, res.Header().Get("Set-Cookie"))
+		}
+		tkn, err := UnmarshalToken(cookies[0].Value, ring)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err.Error())
+		}
+		if !tkn.ExpiredAt().Equal(expiredAt) {
+			t.Errorf("%s: incorrect expiration of the cookie, got %s", tt.name, tkn.ExpiredAt())
+		}
+		if tkn.TokenID() != tokenID || tkn.IssuedAt().Unix() != tt.issuedAt.Unix() {
+			t.Errorf("%s: incorrect renewed token, got %s %s", tt.name, tkn.TokenID(), tkn.IssuedAt())
+		}
+	}
+}
+
+func TestTokenHandler_SlidingExpiration_CookieOptions(t *testing.T) {
+	secretKey := uuid.NewV4().Bytes()
+	cookieName := "__Host-session"
+	opts := CookieOptions{SameSite: http.SameSiteStrictMode, Partitioned: true}
+
+	cookieValue, err := Marshal(uuid.NewV4().String(), uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Minute), secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := TokenHandler(secretKey, cookieName, func(w http.ResponseWriter, r *http.Request) {},
+		WithSlidingExpiration(5*time.Minute, 30*time.Minute, time.Hour), WithCookieOptions(opts))
+	if err != nil {
+		t.Fatalf("could not create nextHandler; details: %s", err.Error())
+	}
+
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: cookieName, Value: cookieValue})
+	h(res, req)
+
+	header := res.Header().Get("Set-Cookie")
+	for _, attribute := range []string{"__Host-session=", "Path=/", "Max-Age=", "HttpOnly", "Secure", "SameSite=Strict", "Partitioned"} {
+		if !strings.Contains(header, attribute) {
+			t.Errorf("incorrect renewed cookie, %s is missing in %s", attribute, header)
+		}
+	}
+
+	for _, opt := range []HandlerOption{
+		WithCookieOptions(CookieOptions{Insecure: true}),
+		WithCookieOptions(CookieOptions{Name: "other"}),
+		WithCookieOptions(CookieOptions{Path: "/api"}),
+	} {
+		if _, err = TokenHandler(secretKey, cookieName, nil, WithSlidingExpiration(5*time.Minute, 30*time.Minute, time.Hour), opt); err == nil {
+			t.Errorf("incorrect cookie options are accepted")
+		}
+	}
+}
+
+func TestWithSlidingExpiration_Rejected(t *testing.T) {
+	secretKey := uuid.NewV4().Bytes()
+	next := func(w http.ResponseWriter, r *http.Request) {}
+
+	for _, opt := range []HandlerOption{
+		WithSlidingExpiration(0, time.Hour, time.Hour),
+		WithSlidingExpiration(2*time.Hour, time.Hour, time.Hour),
+		WithSlidingExpiration(time.Minute, time.Hour, time.Minute),
+	} {
+		if _, err := TokenHandler(secretKey, uuid.NewV4().String(), next, opt); err == nil {
+			t.Errorf("incorrect sliding expiration is accepted")
+		}
+	}
+}
@@ -1,23 +1,44 @@
 package tokeninjector
 
 import (
+	"slices"
 	"time"
 )
 
-// Token is an interface that contains the methods for getting the user id, user name, role id, and expiration time.
+// Token is an interface that contains the methods for getting the user id, user name, role id, expiration time,
+// registered claims (issued-at, not-before, issuer, audience, token id, scopes, generation), and additional claims.
 type Token interface {
 	UserID() string
 	UserName() string
 	UserRoleID() uint64
 	ExpiredAt() time.Time
+	IssuedAt() time.Time
+	NotBefore() time.Time
+	Issuer() string
+	Audience() string
+	TokenID() string
+	Scopes() []string
+	Generation() uint64
+	Claims() Claims
 }
 
-// token is a structure that contains the user id, user name, role id, and expiration time.
+// token is a structure that contains the user id, user name, role id, expiration time,
+// registered claims, and additional claims.
 type token struct {
-	userID    string
-	userName  string
-	roleID    uint64
-	expiredAt time.Time
+	userID     string
+	userName   string
+	roleID     uint64
+	expiredAt  time.Time
+	issuedAt   time.Time
+	notBefore  time.Time
+	issuer     string
+	audience   string
+	tokenID    string
+	scopes     []string
+	generation uint64
+	claims     Claims
+	// sessionExpiredAt is the maximum lifetime of the sliding session set by the middleware, it is not encoded.
+	sessionExpiredAt time.Time
 }
 
 // UserID returns the user id.
@@ -31,3 +52,48 @@ func (t *token) UserRoleID() uint64 { return t.roleID }
 
 // ExpiredAt returns the expiration time.
 func (t *token) ExpiredAt() time.Time { return t.expiredAt }
+
+// IssuedAt returns the issue time, it is zero for tokens issued without it.
+func (t *token) IssuedAt() time.Time { return t.issuedAt }
+
+// NotBefore returns the time before which the token is not valid, it is zero if not set.
+func (t *token) NotBefore() time.Time { return t.notBefore }
+
+// Issuer returns the issuer.
+func (t *token) Issuer() string { return t.issuer }
+
+// Audience returns the audience.
+func (t *token) Audience() string { return t.audience }
+
+// TokenID returns the unique token id.
+func (t *token) TokenID() string { return t.tokenID }
+
+// Scopes returns the scopes granted to the token, see MatchScope.
+func (t *token) Scopes() []string { return slices.Clone(t.scopes) }
+
+// Generation returns the generation of the user sessions the token belongs to, see GenerationStore.
+func (t *token) Generation() uint64 { return t.generation }
+
+// Claims returns the additional claims.
+func (t *token) Claims() Claims { return t.claims }
+
+// tokenOf returns the token of the package with the fields of the token, e.g. returned by a CredentialVerifier.
+func tokenOf(t Token) *token {
+	if v, ok := t.(*token); ok {
+		return v
+	}
+	return &token{
+		userID:     t.UserID(),
+		userName:   t.UserName(),
+		roleID:     t.UserRoleID(),
+		expiredAt:  t.ExpiredAt(),
+		issuedAt:   t.IssuedAt(),
+		notBefore:  t.NotBefore(),
+		issuer:     t.Issuer(),
+		audience:   t.Audience(),
+		tokenID:    t.TokenID(),
+		scopes:     t.Scopes(),
+		generation: t.Generation(),
+		claims:     t.Claims(),
+	}
+}
@@ -0,0 +1,130 @@
+package tokeninjector
+
+import (
+	"time"
+)
+
+// ValidateOption configures the checks of the token performed by Unmarshal, UnmarshalToken and the middleware.
+type ValidateOption func(*validateOptions)
+
+// validateOptions is a structure that contains the checks of the token.
+type validateOptions struct {
+	audience string
+	issuer   string
+	maxAge   time.Duration
+	leeway   time.Duration
+	now      func() time.Time
+	revoked  RevocationStore
+	gens     GenerationStore
+	// skipExpiry leaves the expiration to the caller, like Unmarshal does.
+	skipExpiry bool
+}
+
+// newValidateOptions applies the options to the default checks.
+func newValidateOptions(opts []ValidateOption) *validateOptions {
+	o := &validateOptions{now: time.Now}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithExpectedAudience rejects the tokens issued for another audience.
+func WithExpectedAudience(audience string) ValidateOption {
+	return func(o *validateOptions) {
+		o.audience = audience
+	}
+}
+
+// WithExpectedIssuer rejects the tokens issued by another issuer.
+func WithExpectedIssuer(issuer string) ValidateOption {
+	return func(o *validateOptions) {
+		o.issuer = issuer
+	}
+}
+
+// WithMaxAge rejects the tokens issued earlier than maxAge ago, as well as the tokens without the issue time.
+func WithMaxAge(maxAge time.Duration) ValidateOption {
+	return func(o *validateOptions) {
+		o.maxAge = maxAge
+	}
+}
+
+// WithLeeway sets the allowed clock skew for the checks of the expiration, not-before, and issue time.
+func WithLeeway(leeway time.Duration) ValidateOption {
+	return func(o *validateOptions) {
+		o.leeway = leeway
+	}
+}
+
+// WithRevocationCheck rejects the tokens revoked in the store with ErrRevoked.
+// The tokens without the id (e.g. v1) can not be revoked.
+func WithRevocationCheck(store RevocationStore) ValidateOption {
+	return func(o *validateOptions) {
+		o.revoked = store
+	}
+}
+
+// WithGenerationCheck rejects with ErrRevoked the tokens of the generation older than the current generation
+// of the user in the store, as well as the tokens issued before the cutoff of the store.
+func WithGenerationCheck(store GenerationStore) ValidateOption {
+	return func(o *validateOptions) {
+		o.gens = store
+	}
+}
+
+// validateToken checks the user id, expiration, not-before, and issue time, the audience, the issuer,
+// the revocation and the generation of the token.
+// The returned error is a TokenError with the reason of the rejection.
+func validateToken(t *token, o *validateOptions) error {
+	now := o.now()
+
+	if len(t.userID) == 0 {
+		return newTokenError(ErrInvalidClaims, "no user id")
+	}
+
+	if !o.skipExpiry && !t.expiredAt.After(now.Add(-o.leeway)) {
+		return newTokenError(ErrExpired, "expired at %s", t.expiredAt)
+	}
+	if err := validateNotBefore(t, now.Add(o.leeway)); err != nil {
+		return err
+	}
+	if o.maxAge > 0 {
+		if t.issuedAt.IsZero() {
+			return newTokenError(ErrInvalidClaims, "no issue time")
+		}
+		if now.Sub(t.issuedAt) > o.maxAge+o.leeway {
+			return newTokenError(ErrExpired, "issued at %s is older than %s", t.issuedAt, o.maxAge)
+		}
+	}
+	if len(o.audience) > 0 && t.audience != o.audience {
+		return newTokenError(ErrInvalidClaims, "audience %q does not match %q", t.audience, o.audience)
+	}
+	if len(o.issuer) > 0 && t.issuer != o.issuer {
+		return newTokenError(ErrInvalidClaims, "issuer %q does not match %q", t.issuer, o.issuer)
+	}
+	if o.revoked != nil && len(t.tokenID) > 0 {
+		revoked, err := o.revoked.IsRevoked(t.tokenID)
+		if err != nil {
+			return newTokenError(ErrUnavailable, "could not check the revocation of the token; details: %s", err.Error())
+		}
+		if revoked {
+			return newTokenError(ErrRevoked, "token id %q", t.tokenID)
+		}
+	}
+	if o.gens != nil {
+		if err := validateGeneration(t, o.gens); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateNotBefore checks that the token is already valid at the time.
+func validateNotBefore(t *token, now time.Time) error {
+	if !t.notBefore.IsZero() && now.Before(t.notBefore) {
+		return newTokenError(ErrNotYetValid, "not valid before %s", t.notBefore)
+	}
+	return nil
+}
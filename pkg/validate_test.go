@@ -0,0 +1,124 @@
+package tokeninjector
+
+import (
+	"github.com/twinj/uuid"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestUnmarshalToken_RegisteredClaims(t *testing.T) {
+	ring, err := newSingleKeyRing(uuid.NewV4().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedIssuedAt := time.Unix(time.Now().Add(-time.Minute).Unix(), 0).UTC()
+	expectedNotBefore := time.Unix(time.Now().Add(-time.Second).Unix(), 0).UTC()
+	expectedIssuer := uuid.NewV4().String()
+	expectedAudience := uuid.NewV4().String()
+	expectedTokenID := uuid.NewV4().String()
+
+	dataset, err := MarshalWithKeyRing(uuid.NewV4().String(), uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Hour), ring,
+		WithIssuedAt(expectedIssuedAt),
+		WithNotBefore(expectedNotBefore),
+		WithIssuer(expectedIssuer),
+		WithAudience(expectedAudience),
+		WithTokenID(expectedTokenID),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tkn, err := UnmarshalToken(dataset, ring, WithExpectedAudience(expectedAudience), WithExpectedIssuer(expectedIssuer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := tkn.IssuedAt(); !a.Equal(expectedIssuedAt) {
+		t.Errorf("incorrect token issuedAt, got %s, expected %s", a, expectedIssuedAt)
+	}
+	if a := tkn.NotBefore(); !a.Equal(expectedNotBefore) {
+		t.Errorf("incorrect token notBefore, got %s, expected %s", a, expectedNotBefore)
+	}
+	if a := tkn.Issuer(); a != expectedIssuer {
+		t.Errorf("incorrect token issuer, got %s, expected %s", a, expectedIssuer)
+	}
+	if a := tkn.Audience(); a != expectedAudience {
+		t.Errorf("incorrect token audience, got %s, expected %s", a, expectedAudience)
+	}
+	if a := tkn.TokenID(); a != expectedTokenID {
+		t.Errorf("incorrect token tokenID, got %s, expected %s", a, expectedTokenID)
+	}
+	if tags := tkn.Claims().Tags(); len(tags) != 0 {
+		t.Errorf("registered claims leaked into claims, got %v", tags)
+	}
+}
+
+func TestUnmarshalToken_Defaults(t *testing.T) {
+	ring, err := newSingleKeyRing(uuid.NewV4().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenIDs := make(map[string]struct{})
+	for i := 0; i < 2; i++ {
+		dataset, err := MarshalWithKeyRing(uuid.NewV4().String(), uuid.NewV4().String(), rand.Uint64(), time.Now().Add(time.Hour), ring)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tkn, err := UnmarshalToken(dataset, ring)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := time.Since(tkn.IssuedAt()); d < 0 || d > time.Minute {
+			t.Errorf("incorrect default issuedAt, got %s", tkn.IssuedAt())
+		}
+		if len(tkn.TokenID()) == 0 {
+			t.Errorf("token has no default token id")
+		}
+		tokenIDs[tkn.TokenID()] = struct{}{}
+	}
+	if len(tokenIDs) != 2 {
+		t.Errorf("default token ids are not unique")
+	}
+}
+
+func TestUnmarshalToken_Rejected(t *testing.T) {
+	ring, err := newSingleKeyRing(uuid.NewV4().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	marshal := func(expiredAt time.Time, opts ...MarshalOption) string {
+		dataset, err := MarshalWithKeyRing(uuid.NewV4().String(), uuid.NewV4().String(), rand.Uint64(), expiredAt, ring, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dataset
+	}
+	expiredAt := time.Now().Add(time.Hour)
+
+	testCases := map[string]struct {
+		data string
+		opts []ValidateOption
+	}{
+		"expired":             {data: marshal(time.Now().Add(-time.Second))},
+		"not yet valid":       {data: marshal(expiredAt, WithNotBefore(time.Now().Add(time.Minute)))},
+		"another audience":    {data: marshal(expiredAt, WithAudience("service-a")), opts: []ValidateOption{WithExpectedAudience("service-b")}},
+		"no audience":         {data: marshal(expiredAt), opts: []ValidateOption{WithExpectedAudience("service-b")}},
+		"another issuer":      {data: marshal(expiredAt, WithIssuer("issuer-a")), opts: []ValidateOption{WithExpectedIssuer("issuer-b")}},
+		"older than max age":  {data: marshal(expiredAt, WithIssuedAt(time.Now().Add(-time.Hour))), opts: []ValidateOption{WithMaxAge(time.Minute)}},
+		"without issued time": {data: marshal(expiredAt, WithIssuedAt(time.Time{})), opts: []ValidateOption{WithMaxAge(time.Minute)}},
+	}
+	for name, tc := range testCases {
+		if _, err = UnmarshalToken(tc.data, ring, tc.opts...); err == nil {
+			t.Errorf("%s: token was accepted", name)
+		}
+	}
+
+	data := marshal(expiredAt, WithNotBefore(time.Now().Add(time.Minute)))
+	if _, _, _, _, err = UnmarshalWithKeyRing(data, ring); err == nil {
+		t.Errorf("token that is not valid yet was accepted by UnmarshalWithKeyRing")
+	}
+	if _, err = UnmarshalToken(data, ring, WithLeeway(2*time.Minute)); err != nil {
+		t.Errorf("token within the leeway was rejected; details: %s", err.Error())
+	}
+}